@@ -0,0 +1,328 @@
+package apiserver
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/faceair/clash-speedtest/history"
+	"github.com/faceair/clash-speedtest/metrics"
+	"github.com/faceair/clash-speedtest/speedtester"
+	"github.com/faceair/clash-speedtest/subscription"
+)
+
+// 任务请求体的大小上限
+const maxJobRequestBytes = 1 << 20
+
+type Config struct {
+	// 监听地址，默认只监听本机，监听其他地址时必须设置 Token
+	Listen string
+	// 不为空时所有接口需要携带 Bearer Token
+	Token string
+	// 任务的 config_paths 只允许 http(s) 订阅链接和该目录下的配置文件，为空时只允许订阅链接
+	ConfigDir string
+	// 同时运行的任务数，以及最多排队的任务数
+	MaxRunningJobs int
+	MaxQueuedJobs  int
+	// 任务未指定的参数使用该配置中的值
+	Defaults speedtester.Config
+	// 不为空时保存完成的任务结果，并提供历史查询接口
+	History *history.Store
+
+	// 不为空时 /sub 接口也接受该 Token，便于将订阅地址交给客户端而不暴露 API Token
+	SubscriptionToken string
+	// /sub 接口的默认过滤和重命名参数，可以被查询参数覆盖
+	Subscription subscription.Options
+	// 不为空时记录完成的任务结果，并通过 /metrics 输出 Prometheus 指标
+	Metrics *metrics.Registry
+}
+
+// Server 提供提交测试任务、查询进度、通过 SSE 推送结果和取消任务的 REST API
+type Server struct {
+	config *Config
+	queue  *jobQueue
+	mux    *http.ServeMux
+}
+
+func New(ctx context.Context, config *Config) *Server {
+	if config.Listen == "" {
+		config.Listen = "127.0.0.1:8090"
+	}
+	if config.MaxRunningJobs <= 0 {
+		config.MaxRunningJobs = 1
+	}
+	if config.MaxQueuedJobs <= 0 {
+		config.MaxQueuedJobs = 16
+	}
+
+	s := &Server{
+		config: config,
+		queue:  newJobQueue(ctx, config.MaxRunningJobs, config.MaxQueuedJobs, config.History, config.Metrics),
+		mux:    http.NewServeMux(),
+	}
+	s.mux.HandleFunc("POST /jobs", s.auth(s.handleSubmit))
+	s.mux.HandleFunc("GET /jobs", s.auth(s.handleList))
+	s.mux.HandleFunc("GET /jobs/{id}", s.auth(s.handleStatus))
+	s.mux.HandleFunc("DELETE /jobs/{id}", s.auth(s.handleCancel))
+	s.mux.HandleFunc("GET /jobs/{id}/results", s.auth(s.handleResults))
+	s.mux.HandleFunc("GET /jobs/{id}/events", s.auth(s.handleEvents))
+	// /sub 没有其他限制，未设置任何 Token 时不提供
+	if config.Token != "" || config.SubscriptionToken != "" {
+		s.mux.HandleFunc("GET /sub", s.subAuth(s.handleSubscription))
+	}
+	if config.History != nil {
+		s.registerHistory()
+	}
+	if config.Metrics != nil {
+		s.mux.Handle("GET /metrics", s.auth(config.Metrics.ServeHTTP))
+	}
+	return s
+}
+
+func (s *Server) Handler() http.Handler {
+	return s.mux
+}
+
+// ListenAndServe 开始监听，未设置 Token 时拒绝监听本机以外的地址
+func (s *Server) ListenAndServe() error {
+	if s.config.Token == "" && !isLoopback(s.config.Listen) {
+		return fmt.Errorf("refuse to listen on %s without a token, set a token or listen on a loopback address", s.config.Listen)
+	}
+	server := &http.Server{
+		Addr:              s.config.Listen,
+		Handler:           s.mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	return server.ListenAndServe()
+}
+
+// isLoopback 判断监听地址是否只能从本机访问，省略主机时监听所有地址
+func isLoopback(listen string) bool {
+	host, _, err := net.SplitHostPort(listen)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// auth 校验 Authorization: Bearer <token>，也接受 ?token= 参数以便浏览器直接订阅 SSE
+func (s *Server) auth(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if s.config.Token != "" && !matchToken(r, s.config.Token) {
+			writeError(w, http.StatusUnauthorized, errors.New("unauthorized"))
+			return
+		}
+		next(w, r)
+	}
+}
+
+// subAuth 在 auth 的基础上额外接受 SubscriptionToken，只设置了 SubscriptionToken 时 /sub 同样需要校验
+func (s *Server) subAuth(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if s.config.SubscriptionToken == "" {
+			s.auth(next)(w, r)
+			return
+		}
+		if !matchToken(r, s.config.SubscriptionToken) && (s.config.Token == "" || !matchToken(r, s.config.Token)) {
+			writeError(w, http.StatusUnauthorized, errors.New("unauthorized"))
+			return
+		}
+		next(w, r)
+	}
+}
+
+// resolveConfigPaths 检查任务的配置路径，只允许 http(s) 订阅链接和 configDir 目录下的文件，
+// 避免通过 API 读取任意本地文件。相对路径相对于 configDir，返回解析后的路径
+func resolveConfigPaths(paths []string, configDir string) ([]string, error) {
+	resolved := make([]string, 0, len(paths))
+	for _, path := range paths {
+		if strings.Contains(path, ",") {
+			return nil, fmt.Errorf("config path %q must not contain a comma", path)
+		}
+		if u, err := url.Parse(path); err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != "" {
+			resolved = append(resolved, path)
+			continue
+		}
+		if configDir == "" {
+			return nil, fmt.Errorf("config path %q is not allowed, only http(s) urls are accepted", path)
+		}
+
+		dir, err := filepath.EvalSymlinks(configDir)
+		if err != nil {
+			return nil, fmt.Errorf("resolve config dir failed: %w", err)
+		}
+		dir, err = filepath.Abs(dir)
+		if err != nil {
+			return nil, fmt.Errorf("resolve config dir failed: %w", err)
+		}
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(dir, path)
+		}
+		// 解析符号链接后再判断，避免通过目录中的链接读取其他文件
+		file, err := filepath.EvalSymlinks(path)
+		if err != nil {
+			return nil, fmt.Errorf("config path %q not found", path)
+		}
+		if rel, err := filepath.Rel(dir, file); err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return nil, fmt.Errorf("config path %q is outside of the config dir", path)
+		}
+		resolved = append(resolved, file)
+	}
+	return resolved, nil
+}
+
+func matchToken(r *http.Request, expected string) bool {
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if token == "" {
+		token = r.URL.Query().Get("token")
+	}
+	return subtle.ConstantTimeCompare([]byte(token), []byte(expected)) == 1
+}
+
+func (s *Server) handleSubmit(w http.ResponseWriter, r *http.Request) {
+	req := &JobRequest{}
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxJobRequestBytes)).Decode(req); err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			writeError(w, http.StatusRequestEntityTooLarge, fmt.Errorf("job request exceeds the limit of %d bytes", maxBytesErr.Limit))
+			return
+		}
+		writeError(w, http.StatusBadRequest, fmt.Errorf("invalid job request: %w", err))
+		return
+	}
+	paths, err := resolveConfigPaths(req.ConfigPaths, s.config.ConfigDir)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	req.ConfigPaths = paths
+	config, err := req.BuildConfig(s.config.Defaults)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	job := newJob(req, config)
+	if err := s.queue.Submit(job); err != nil {
+		writeError(w, http.StatusServiceUnavailable, err)
+		return
+	}
+	writeJSON(w, http.StatusAccepted, job.snapshot())
+}
+
+func (s *Server) handleList(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, s.queue.List())
+}
+
+func (s *Server) handleStatus(w http.ResponseWriter, r *http.Request) {
+	job, ok := s.job(w, r)
+	if !ok {
+		return
+	}
+	writeJSON(w, http.StatusOK, job.snapshot())
+}
+
+func (s *Server) handleCancel(w http.ResponseWriter, r *http.Request) {
+	job, ok := s.job(w, r)
+	if !ok {
+		return
+	}
+	job.Cancel()
+	writeJSON(w, http.StatusOK, job.snapshot())
+}
+
+func (s *Server) handleResults(w http.ResponseWriter, r *http.Request) {
+	job, ok := s.job(w, r)
+	if !ok {
+		return
+	}
+	writeJSON(w, http.StatusOK, job.Results())
+}
+
+// handleEvents 通过 SSE 推送测试结果。连接建立后先发送已有的结果，之后每完成一个节点发送一个
+// result 事件，进度变化时发送 status 事件，任务结束时发送 done 事件并关闭连接
+func (s *Server) handleEvents(w http.ResponseWriter, r *http.Request) {
+	job, ok := s.job(w, r)
+	if !ok {
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, errors.New("streaming not supported"))
+		return
+	}
+
+	notify, unsubscribe := job.subscribe()
+	defer unsubscribe()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+
+	sent := 0
+	var lastStatus JobStatus
+	var lastDone int
+	for {
+		results, status := job.resultsSince(sent)
+		for _, result := range results {
+			writeEvent(w, "result", result)
+		}
+		sent += len(results)
+		if status.Status != lastStatus || status.Done != lastDone {
+			writeEvent(w, "status", status)
+			lastStatus, lastDone = status.Status, status.Done
+		}
+		if status.Status.Finished() {
+			writeEvent(w, "done", status)
+			flusher.Flush()
+			return
+		}
+		flusher.Flush()
+
+		select {
+		case <-notify:
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+func (s *Server) job(w http.ResponseWriter, r *http.Request) (*Job, bool) {
+	job, err := s.queue.Get(r.PathValue("id"))
+	if err != nil {
+		writeError(w, http.StatusNotFound, err)
+		return nil, false
+	}
+	return job, true
+}
+
+func writeEvent(w http.ResponseWriter, event string, data any) {
+	body, err := json.Marshal(data)
+	if err != nil {
+		return
+	}
+	fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, body)
+}
+
+func writeJSON(w http.ResponseWriter, status int, data any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(data)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
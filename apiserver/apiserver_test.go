@@ -0,0 +1,290 @@
+package apiserver
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/faceair/clash-speedtest/speedtester"
+)
+
+func TestIsLoopback(t *testing.T) {
+	tests := []struct {
+		listen string
+		want   bool
+	}{
+		{listen: "127.0.0.1:8090", want: true},
+		{listen: "localhost:8090", want: true},
+		{listen: "[::1]:8090", want: true},
+		{listen: ":8090", want: false},
+		{listen: "0.0.0.0:8090", want: false},
+		{listen: "192.168.1.2:8090", want: false},
+		{listen: "8090", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.listen, func(t *testing.T) {
+			if got := isLoopback(tt.listen); got != tt.want {
+				t.Errorf("isLoopback(%q) = %v, want %v", tt.listen, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestListenAndServeRequiresToken(t *testing.T) {
+	s := &Server{config: &Config{Listen: "0.0.0.0:0"}}
+	if err := s.ListenAndServe(); err == nil {
+		t.Fatal("ListenAndServe() on a public address without token succeeded")
+	}
+}
+
+func TestResolveConfigPaths(t *testing.T) {
+	dir := t.TempDir()
+	config := filepath.Join(dir, "config.yaml")
+	if err := os.WriteFile(config, []byte("proxies: []\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	outside := filepath.Join(t.TempDir(), "secret.yaml")
+	if err := os.WriteFile(outside, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(outside, filepath.Join(dir, "link.yaml")); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		paths     []string
+		configDir string
+		want      []string
+		wantErr   bool
+	}{
+		{name: "https url", paths: []string{"https://example.com/sub?token=1"}, want: []string{"https://example.com/sub?token=1"}},
+		{name: "http url", paths: []string{"http://example.com/sub"}, configDir: dir, want: []string{"http://example.com/sub"}},
+		{name: "file without config dir", paths: []string{config}, wantErr: true},
+		{name: "file url", paths: []string{"file:///etc/passwd"}, configDir: dir, wantErr: true},
+		{name: "relative file", paths: []string{"config.yaml"}, configDir: dir, want: []string{config}},
+		{name: "absolute file", paths: []string{config}, configDir: dir, want: []string{config}},
+		{name: "parent traversal", paths: []string{"../" + filepath.Base(dir) + "/../x"}, configDir: dir, wantErr: true},
+		{name: "outside absolute", paths: []string{outside}, configDir: dir, wantErr: true},
+		{name: "symlink escape", paths: []string{"link.yaml"}, configDir: dir, wantErr: true},
+		{name: "comma", paths: []string{"https://example.com/a,/etc/passwd"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := resolveConfigPaths(tt.paths, tt.configDir)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("resolveConfigPaths() = %v, want error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("resolveConfigPaths() error = %v", err)
+			}
+			// 临时目录本身可能经过符号链接
+			want := make([]string, len(tt.want))
+			for i, path := range tt.want {
+				if resolved, err := filepath.EvalSymlinks(path); err == nil {
+					path = resolved
+				}
+				want[i] = path
+			}
+			if len(got) != len(want) {
+				t.Fatalf("resolveConfigPaths() = %v, want %v", got, want)
+			}
+			for i := range got {
+				if got[i] != want[i] {
+					t.Errorf("resolveConfigPaths()[%d] = %s, want %s", i, got[i], want[i])
+				}
+			}
+		})
+	}
+}
+
+func TestBuildConfigBoolOverride(t *testing.T) {
+	yes, no := true, false
+	tests := []struct {
+		name     string
+		defaults bool
+		value    *bool
+		want     bool
+	}{
+		{name: "unset keeps default on", defaults: true, want: true},
+		{name: "unset keeps default off", defaults: false, want: false},
+		{name: "false disables default", defaults: true, value: &no, want: false},
+		{name: "true enables", defaults: false, value: &yes, want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defaults := speedtester.Config{
+				ConfigPaths: "config.yaml",
+				Fast:        tt.defaults,
+				UDPTest:     tt.defaults,
+				RouteCheck:  tt.defaults,
+				EntryProbe:  tt.defaults,
+			}
+			req := &JobRequest{Fast: tt.value, UDPTest: tt.value, RouteCheck: tt.value, EntryProbe: tt.value}
+			config, err := req.BuildConfig(defaults)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if config.Fast != tt.want || config.UDPTest != tt.want || config.RouteCheck != tt.want || config.EntryProbe != tt.want {
+				t.Errorf("fast=%v udp=%v route_check=%v entry_probe=%v, want %v",
+					config.Fast, config.UDPTest, config.RouteCheck, config.EntryProbe, tt.want)
+			}
+		})
+	}
+}
+
+func TestSubscriptionRequiresToken(t *testing.T) {
+	tests := []struct {
+		name     string
+		config   Config
+		query    string
+		wantCode int
+		wantBody string
+	}{
+		{name: "no token disables sub", wantCode: http.StatusNotFound, wantBody: "page not found"},
+		{name: "api token required", config: Config{Token: "secret"}, wantCode: http.StatusUnauthorized},
+		{name: "api token accepted", config: Config{Token: "secret"}, query: "?token=secret", wantCode: http.StatusNotFound, wantBody: "no test results yet"},
+		{name: "sub token accepted", config: Config{Token: "secret", SubscriptionToken: "sub"}, query: "?token=sub", wantCode: http.StatusNotFound, wantBody: "no test results yet"},
+		{name: "sub token only", config: Config{SubscriptionToken: "sub"}, query: "?token=wrong", wantCode: http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			s := New(ctx, &tt.config)
+			w := httptest.NewRecorder()
+			s.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/sub"+tt.query, nil))
+			// 通过认证后没有测试结果时返回 404
+			if w.Code != tt.wantCode || !strings.Contains(w.Body.String(), tt.wantBody) {
+				t.Errorf("status = %d %q, want %d %q", w.Code, w.Body.String(), tt.wantCode, tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestBuildConfigOverrides(t *testing.T) {
+	defaults := speedtester.Config{
+		ConfigPaths:    "config.yaml",
+		DownloadWarmup: 2 * time.Second,
+		Payload:        "random",
+		ScalingStreams: []int{4, 1},
+	}
+	tests := []struct {
+		name    string
+		body    string
+		check   func(config *speedtester.Config) bool
+		wantErr bool
+	}{
+		{
+			name: "duration mode",
+			body: `{"download_duration":"10s","download_warmup":"1s"}`,
+			check: func(c *speedtester.Config) bool {
+				return c.DownloadDuration == 10*time.Second && c.DownloadWarmup == time.Second
+			},
+		},
+		{
+			name:    "warmup longer than duration",
+			body:    `{"download_duration":"1s"}`,
+			wantErr: true,
+		},
+		{
+			name:  "soak",
+			body:  `{"soak_duration":"30s","soak_size":"1GB"}`,
+			check: func(c *speedtester.Config) bool { return c.SoakDuration == 30*time.Second && c.SoakSize == 1<<30 },
+		},
+		{
+			name:  "scaling",
+			body:  `{"scaling":[1,2,8]}`,
+			check: func(c *speedtester.Config) bool { return len(c.ScalingStreams) == 3 && c.ScalingStreams[2] == 8 },
+		},
+		{
+			name:    "invalid scaling",
+			body:    `{"scaling":[0]}`,
+			wantErr: true,
+		},
+		{
+			name: "loaded latency and rounds",
+			body: `{"loaded_latency":true,"rounds":3,"round_interval":"1m"}`,
+			check: func(c *speedtester.Config) bool {
+				return c.LoadedLatency && c.Rounds == 3 && c.RoundInterval == time.Minute
+			},
+		},
+		{
+			name:  "payload",
+			body:  `{"payload":"zero"}`,
+			check: func(c *speedtester.Config) bool { return c.Payload == "zero" },
+		},
+		{
+			name:    "invalid payload",
+			body:    `{"payload":"text"}`,
+			wantErr: true,
+		},
+		{
+			name:  "thresholds",
+			body:  `{"max_packet_loss":5,"max_failure_rate":10}`,
+			check: func(c *speedtester.Config) bool { return c.MaxPacketLoss == 5 && c.MaxFailureRate == 10 },
+		},
+		{
+			name:  "traffic budget string",
+			body:  `{"traffic_budget":"20GB"}`,
+			check: func(c *speedtester.Config) bool { return c.TrafficBudget == 20<<30 },
+		},
+		{
+			name:  "traffic budget bytes",
+			body:  `{"traffic_budget":1048576}`,
+			check: func(c *speedtester.Config) bool { return c.TrafficBudget == 1<<20 },
+		},
+		{
+			name:    "invalid traffic budget",
+			body:    `{"traffic_budget":"lots"}`,
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := &JobRequest{}
+			err := json.Unmarshal([]byte(tt.body), req)
+			var config *speedtester.Config
+			if err == nil {
+				config, err = req.BuildConfig(defaults)
+			}
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("BuildConfig(%s) succeeded, want error", tt.body)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("BuildConfig(%s) error = %v", tt.body, err)
+			}
+			if !tt.check(config) {
+				t.Errorf("BuildConfig(%s) = %+v", tt.body, config)
+			}
+		})
+	}
+	// 任务的并发连接数列表不能与默认配置共用
+	if defaults.ScalingStreams[0] != 4 {
+		t.Errorf("default scaling streams modified: %v", defaults.ScalingStreams)
+	}
+}
+
+func TestSubmitTooLarge(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	s := New(ctx, &Config{})
+	body := `{"filter_regex":"` + strings.Repeat("a", maxJobRequestBytes) + `"}`
+	w := httptest.NewRecorder()
+	s.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/jobs", strings.NewReader(body)))
+	if w.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("status = %d %q, want %d", w.Code, w.Body.String(), http.StatusRequestEntityTooLarge)
+	}
+}
@@ -0,0 +1,108 @@
+package apiserver
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/faceair/clash-speedtest/history"
+)
+
+// 历史查询未指定 since 时默认查询最近一天
+const defaultHistoryWindow = 24 * time.Hour
+
+func (s *Server) registerHistory() {
+	s.mux.HandleFunc("GET /history/nodes", s.auth(s.handleHistoryNodes))
+	s.mux.HandleFunc("GET /history/nodes/{fingerprint}", s.auth(s.handleHistoryPoints))
+	s.mux.HandleFunc("GET /history/nodes/{fingerprint}/records", s.auth(s.handleHistoryRecords))
+	s.mux.HandleFunc("GET /history/nodes/{fingerprint}/uptime", s.auth(s.handleHistoryUptime))
+	s.mux.HandleFunc("GET /history/runs", s.auth(s.handleHistoryRuns))
+}
+
+// handleHistoryNodes 返回所有节点，?name= 按名称查找节点的指纹
+func (s *Server) handleHistoryNodes(w http.ResponseWriter, r *http.Request) {
+	nodes, err := s.config.History.Nodes(r.URL.Query().Get("name"))
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, nodes)
+}
+
+func (s *Server) handleHistoryPoints(w http.ResponseWriter, r *http.Request) {
+	since, until, ok := historyRange(w, r)
+	if !ok {
+		return
+	}
+	points, err := s.config.History.History(r.PathValue("fingerprint"), since, until)
+	writeHistory(w, points, err)
+}
+
+func (s *Server) handleHistoryRecords(w http.ResponseWriter, r *http.Request) {
+	since, until, ok := historyRange(w, r)
+	if !ok {
+		return
+	}
+	records, err := s.config.History.Records(r.PathValue("fingerprint"), since, until)
+	writeHistory(w, records, err)
+}
+
+func (s *Server) handleHistoryUptime(w http.ResponseWriter, r *http.Request) {
+	since, until, ok := historyRange(w, r)
+	if !ok {
+		return
+	}
+	uptime, err := s.config.History.Uptime(r.PathValue("fingerprint"), since, until)
+	writeHistory(w, uptime, err)
+}
+
+func (s *Server) handleHistoryRuns(w http.ResponseWriter, r *http.Request) {
+	since, until, ok := historyRange(w, r)
+	if !ok {
+		return
+	}
+	runs, err := s.config.History.Runs(since, until)
+	writeHistory(w, runs, err)
+}
+
+func writeHistory(w http.ResponseWriter, data any, err error) {
+	switch {
+	case errors.Is(err, history.ErrNodeNotFound):
+		writeError(w, http.StatusNotFound, err)
+	case err != nil:
+		writeError(w, http.StatusInternalServerError, err)
+	default:
+		writeJSON(w, http.StatusOK, data)
+	}
+}
+
+// historyRange 解析查询的时间范围，since 和 until 支持 RFC 3339 时间或相对于现在的时长，例如 since=168h
+func historyRange(w http.ResponseWriter, r *http.Request) (time.Time, time.Time, bool) {
+	now := time.Now()
+	since, err := parseHistoryTime(r.URL.Query().Get("since"), now, now.Add(-defaultHistoryWindow))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return time.Time{}, time.Time{}, false
+	}
+	until, err := parseHistoryTime(r.URL.Query().Get("until"), now, now.Add(time.Second))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return time.Time{}, time.Time{}, false
+	}
+	return since, until, true
+}
+
+func parseHistoryTime(value string, now, fallback time.Time) (time.Time, error) {
+	if value == "" {
+		return fallback, nil
+	}
+	if d, err := time.ParseDuration(value); err == nil {
+		return now.Add(-d), nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid time: %s", value)
+	}
+	return t, nil
+}
@@ -0,0 +1,612 @@
+package apiserver
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/faceair/clash-speedtest/history"
+	"github.com/faceair/clash-speedtest/metrics"
+	"github.com/faceair/clash-speedtest/speedtester"
+	"gopkg.in/yaml.v3"
+)
+
+// JobStatus 是任务的状态
+type JobStatus string
+
+const (
+	JobQueued    JobStatus = "queued"
+	JobRunning   JobStatus = "running"
+	JobCompleted JobStatus = "completed"
+	JobFailed    JobStatus = "failed"
+	JobCanceled  JobStatus = "canceled"
+)
+
+// Finished 判断任务是否已经结束
+func (s JobStatus) Finished() bool {
+	return s == JobCompleted || s == JobFailed || s == JobCanceled
+}
+
+// 保留的任务数，超出时删除最早结束的任务
+const maxJobHistory = 100
+
+var (
+	ErrQueueFull   = errors.New("job queue is full")
+	ErrJobNotFound = errors.New("job not found")
+)
+
+// Duration 在 JSON 和 YAML 中使用 "5s"、"800ms" 这样的字符串表示，也接受纳秒数
+type Duration time.Duration
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var value any
+	if err := json.Unmarshal(data, &value); err != nil {
+		return err
+	}
+	switch v := value.(type) {
+	case float64:
+		*d = Duration(v)
+	case string:
+		parsed, err := time.ParseDuration(v)
+		if err != nil {
+			return err
+		}
+		*d = Duration(parsed)
+	default:
+		return fmt.Errorf("invalid duration: %s", data)
+	}
+	return nil
+}
+
+func (d *Duration) UnmarshalYAML(value *yaml.Node) error {
+	parsed, err := time.ParseDuration(value.Value)
+	if err != nil {
+		return err
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+// Size 在 JSON 和 YAML 中使用 "500MB"、"20GB" 这样的字符串表示，也接受字节数
+type Size int64
+
+func (s Size) MarshalJSON() ([]byte, error) {
+	return json.Marshal(speedtester.FormatSize(int64(s)))
+}
+
+func (s *Size) UnmarshalJSON(data []byte) error {
+	var value any
+	if err := json.Unmarshal(data, &value); err != nil {
+		return err
+	}
+	switch v := value.(type) {
+	case float64:
+		if v < 0 {
+			return fmt.Errorf("invalid size: %s", data)
+		}
+		*s = Size(v)
+	case string:
+		parsed, err := speedtester.ParseSize(v)
+		if err != nil {
+			return err
+		}
+		*s = Size(parsed)
+	default:
+		return fmt.Errorf("invalid size: %s", data)
+	}
+	return nil
+}
+
+func (s *Size) UnmarshalYAML(value *yaml.Node) error {
+	parsed, err := speedtester.ParseSize(value.Value)
+	if err != nil {
+		return err
+	}
+	*s = Size(parsed)
+	return nil
+}
+
+// JobRequest 是提交测试任务的参数，与命令行参数含义相同，未指定的参数使用服务启动时的命令行参数
+type JobRequest struct {
+	// 配置文件路径或订阅链接，未指定时使用 -c 参数
+	ConfigPaths []string `json:"config_paths,omitempty" yaml:"config-paths"`
+	FilterRegex string   `json:"filter_regex,omitempty" yaml:"filter-regex"`
+
+	Backend     string `json:"backend,omitempty" yaml:"backend"`
+	ServerURL   string `json:"server_url,omitempty" yaml:"server-url"`
+	ServerToken string `json:"server_token,omitempty" yaml:"server-token"`
+	LatencyURL  string `json:"latency_url,omitempty" yaml:"latency-url"`
+
+	DownloadSize     int      `json:"download_size,omitempty" yaml:"download-size"`
+	DownloadDuration Duration `json:"download_duration,omitempty" yaml:"download-duration"`
+	DownloadWarmup   Duration `json:"download_warmup,omitempty" yaml:"download-warmup"`
+	UploadSize       int      `json:"upload_size,omitempty" yaml:"upload-size"`
+	Payload          string   `json:"payload,omitempty" yaml:"payload"`
+	SoakDuration     Duration `json:"soak_duration,omitempty" yaml:"soak-duration"`
+	SoakSize         Size     `json:"soak_size,omitempty" yaml:"soak-size"`
+	Timeout          Duration `json:"timeout,omitempty" yaml:"timeout"`
+	Concurrent       int      `json:"concurrent,omitempty" yaml:"concurrent"`
+	Scaling          []int    `json:"scaling,omitempty" yaml:"scaling"`
+	TestConcurrent   int      `json:"test_concurrent,omitempty" yaml:"test-concurrent"`
+	Rounds           int      `json:"rounds,omitempty" yaml:"rounds"`
+	RoundInterval    Duration `json:"round_interval,omitempty" yaml:"round-interval"`
+	MaxStreams       int      `json:"max_streams,omitempty" yaml:"max-streams"`
+	TrafficBudget    Size     `json:"traffic_budget,omitempty" yaml:"traffic-budget"`
+
+	// 开关参数未指定时使用默认值，指定为 false 时可以关闭默认开启的测试
+	UnlockTest    string `json:"unlock,omitempty" yaml:"unlock"`
+	Fast          *bool  `json:"fast,omitempty" yaml:"fast"`
+	LoadedLatency *bool  `json:"loaded_latency,omitempty" yaml:"loaded-latency"`
+	UDPTest       *bool  `json:"udp,omitempty" yaml:"udp"`
+	UDPServer     string `json:"udp_server,omitempty" yaml:"udp-server"`
+	RouteCheck    *bool  `json:"route_check,omitempty" yaml:"route-check"`
+	EntryProbe    *bool  `json:"entry_probe,omitempty" yaml:"entry-probe"`
+
+	MaxLatency       Duration `json:"max_latency,omitempty" yaml:"max-latency"`
+	MinDownloadSpeed float64  `json:"min_download_speed,omitempty" yaml:"min-download-speed"`
+	MinUploadSpeed   float64  `json:"min_upload_speed,omitempty" yaml:"min-upload-speed"`
+	MaxPacketLoss    float64  `json:"max_packet_loss,omitempty" yaml:"max-packet-loss"`
+	MaxFailureRate   float64  `json:"max_failure_rate,omitempty" yaml:"max-failure-rate"`
+}
+
+// BuildConfig 在默认配置的基础上应用任务参数
+func (req *JobRequest) BuildConfig(defaults speedtester.Config) (*speedtester.Config, error) {
+	config := defaults
+	if len(req.ConfigPaths) > 0 {
+		config.ConfigPaths = strings.Join(req.ConfigPaths, ",")
+	}
+	if config.ConfigPaths == "" {
+		return nil, fmt.Errorf("config_paths is required")
+	}
+	if req.FilterRegex != "" {
+		config.FilterRegex = req.FilterRegex
+	}
+	if config.FilterRegex == "" {
+		config.FilterRegex = ".+"
+	}
+
+	// 指定测速服务器时重新创建测速后端，不再使用默认配置中的多个测速服务器
+	if req.Backend != "" || req.ServerURL != "" || req.ServerToken != "" {
+		kind := req.Backend
+		if kind == "" && config.SpeedBackend != nil {
+			kind = config.SpeedBackend.Name()
+		}
+		serverURL := req.ServerURL
+		if serverURL == "" {
+			serverURL = config.ServerURL
+		}
+		backend, err := speedtester.NewSpeedBackend(kind, serverURL)
+		if err != nil {
+			return nil, err
+		}
+		config.ServerURL = serverURL
+		config.SpeedBackend = speedtester.WithToken(backend, req.ServerToken)
+		config.SpeedServers = nil
+		config.SpeedServerMap = nil
+	}
+	if req.LatencyURL != "" {
+		config.LatencyURL = req.LatencyURL
+	}
+
+	if req.DownloadSize > 0 {
+		config.DownloadSize = req.DownloadSize
+	}
+	if req.DownloadDuration > 0 {
+		config.DownloadDuration = time.Duration(req.DownloadDuration)
+	}
+	if req.DownloadWarmup > 0 {
+		config.DownloadWarmup = time.Duration(req.DownloadWarmup)
+	}
+	if req.UploadSize > 0 {
+		config.UploadSize = req.UploadSize
+	}
+	if req.Payload != "" {
+		if _, err := speedtester.NewPayloadReader(req.Payload, 0); err != nil {
+			return nil, err
+		}
+		config.Payload = req.Payload
+	}
+	if req.SoakDuration > 0 {
+		config.SoakDuration = time.Duration(req.SoakDuration)
+	}
+	if req.SoakSize > 0 {
+		config.SoakSize = int64(req.SoakSize)
+	}
+	if req.Timeout > 0 {
+		config.Timeout = time.Duration(req.Timeout)
+	}
+	if req.Concurrent > 0 {
+		config.Concurrent = req.Concurrent
+	}
+	if len(req.Scaling) > 0 {
+		for _, n := range req.Scaling {
+			if n <= 0 {
+				return nil, fmt.Errorf("invalid scaling stream count: %d", n)
+			}
+		}
+		// 不修改默认配置共用的切片，New 会对其排序
+		config.ScalingStreams = append([]int(nil), req.Scaling...)
+	}
+	if req.TestConcurrent > 0 {
+		config.TestConcurrent = req.TestConcurrent
+	}
+	if req.Rounds > 0 {
+		config.Rounds = req.Rounds
+	}
+	if req.RoundInterval > 0 {
+		config.RoundInterval = time.Duration(req.RoundInterval)
+	}
+	if req.MaxStreams > 0 {
+		config.MaxStreams = req.MaxStreams
+	}
+	if req.TrafficBudget > 0 {
+		config.TrafficBudget = int64(req.TrafficBudget)
+	}
+	// 预热时间需要短于按时长下载和持续下载的时长
+	if config.DownloadDuration > 0 && config.DownloadWarmup >= config.DownloadDuration {
+		return nil, fmt.Errorf("download_warmup %s must be shorter than download_duration %s", config.DownloadWarmup, config.DownloadDuration)
+	}
+	if config.SoakDuration > 0 && config.DownloadWarmup >= config.SoakDuration {
+		return nil, fmt.Errorf("download_warmup %s must be shorter than soak_duration %s", config.DownloadWarmup, config.SoakDuration)
+	}
+
+	if req.UnlockTest != "" {
+		config.UnlockTest = req.UnlockTest
+	}
+	if req.Fast != nil {
+		config.Fast = *req.Fast
+	}
+	if req.LoadedLatency != nil {
+		config.LoadedLatency = *req.LoadedLatency
+	}
+	if req.UDPTest != nil {
+		config.UDPTest = *req.UDPTest
+	}
+	if req.UDPServer != "" {
+		config.UDPServer = req.UDPServer
+	}
+	if req.RouteCheck != nil {
+		config.RouteCheck = *req.RouteCheck
+	}
+	if req.EntryProbe != nil {
+		config.EntryProbe = *req.EntryProbe
+	}
+
+	if req.MaxLatency > 0 {
+		config.MaxLatency = time.Duration(req.MaxLatency)
+	}
+	if req.MinDownloadSpeed > 0 {
+		config.MinDownloadSpeed = req.MinDownloadSpeed
+	}
+	if req.MinUploadSpeed > 0 {
+		config.MinUploadSpeed = req.MinUploadSpeed
+	}
+	if req.MaxPacketLoss > 0 {
+		config.MaxPacketLoss = req.MaxPacketLoss
+	}
+	if req.MaxFailureRate > 0 {
+		config.MaxFailureRate = req.MaxFailureRate
+	}
+	return &config, nil
+}
+
+// Job 是一次测试任务，测试结果按 TestProxies 的回调顺序追加
+type Job struct {
+	ID         string      `json:"id"`
+	Status     JobStatus   `json:"status"`
+	Request    *JobRequest `json:"request"`
+	Total      int         `json:"total"`
+	Done       int         `json:"done"`
+	Error      string      `json:"error,omitempty"`
+	CreatedAt  time.Time   `json:"created_at"`
+	StartedAt  *time.Time  `json:"started_at,omitempty"`
+	FinishedAt *time.Time  `json:"finished_at,omitempty"`
+
+	TrafficUsed int64                    `json:"traffic_used"`
+	Metadata    *speedtester.RunMetadata `json:"metadata,omitempty"`
+
+	mu          sync.Mutex
+	config      *speedtester.Config
+	results     []*speedtester.Result
+	cancel      context.CancelFunc
+	subscribers map[chan struct{}]struct{}
+}
+
+// JobResults 是任务结果接口的返回值
+type JobResults struct {
+	*Job
+	Results []*speedtester.Result `json:"results"`
+}
+
+func newJob(req *JobRequest, config *speedtester.Config) *Job {
+	id := make([]byte, 8)
+	rand.Read(id)
+	// 任务状态中不返回测速服务器的 Token
+	display := *req
+	if display.ServerToken != "" {
+		display.ServerToken = "******"
+	}
+	return &Job{
+		ID:          hex.EncodeToString(id),
+		Status:      JobQueued,
+		Request:     &display,
+		CreatedAt:   time.Now(),
+		config:      config,
+		subscribers: make(map[chan struct{}]struct{}),
+	}
+}
+
+// snapshot 返回任务状态的副本，读取期间不会被测试过程修改
+func (j *Job) snapshot() *Job {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.snapshotLocked()
+}
+
+func (j *Job) snapshotLocked() *Job {
+	return &Job{
+		ID:          j.ID,
+		Status:      j.Status,
+		Request:     j.Request,
+		Total:       j.Total,
+		Done:        j.Done,
+		Error:       j.Error,
+		CreatedAt:   j.CreatedAt,
+		StartedAt:   j.StartedAt,
+		FinishedAt:  j.FinishedAt,
+		TrafficUsed: j.TrafficUsed,
+		Metadata:    j.Metadata,
+	}
+}
+
+// Results 返回任务状态和目前已完成节点的测试结果
+func (j *Job) Results() *JobResults {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	results := make([]*speedtester.Result, len(j.results))
+	copy(results, j.results)
+	return &JobResults{Job: j.snapshotLocked(), Results: results}
+}
+
+// resultsSince 返回第 n 个之后的结果以及任务当前的状态
+func (j *Job) resultsSince(n int) ([]*speedtester.Result, *Job) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	var results []*speedtester.Result
+	if n < len(j.results) {
+		results = make([]*speedtester.Result, len(j.results)-n)
+		copy(results, j.results[n:])
+	}
+	return results, j.snapshotLocked()
+}
+
+// subscribe 注册一个通知通道，任务有新结果或状态变化时会收到通知
+func (j *Job) subscribe() (chan struct{}, func()) {
+	ch := make(chan struct{}, 1)
+	j.mu.Lock()
+	j.subscribers[ch] = struct{}{}
+	j.mu.Unlock()
+	return ch, func() {
+		j.mu.Lock()
+		delete(j.subscribers, ch)
+		j.mu.Unlock()
+	}
+}
+
+// notifyLocked 通知所有订阅者，通知通道已满时跳过，订阅者会一次读取所有新结果
+func (j *Job) notifyLocked() {
+	for ch := range j.subscribers {
+		select {
+		case ch <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (j *Job) finishLocked(status JobStatus, err error) {
+	j.Status = status
+	if err != nil {
+		j.Error = err.Error()
+	}
+	now := time.Now()
+	j.FinishedAt = &now
+	j.notifyLocked()
+}
+
+// run 加载节点并执行测试，每完成一个节点就通知订阅者
+func (j *Job) run(ctx context.Context) {
+	j.mu.Lock()
+	if j.Status != JobQueued {
+		j.mu.Unlock()
+		return
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	j.cancel = cancel
+	j.Status = JobRunning
+	now := time.Now()
+	j.StartedAt = &now
+	j.notifyLocked()
+	j.mu.Unlock()
+
+	tester := speedtester.New(j.config)
+	proxies, err := tester.LoadProxies()
+	if err != nil {
+		j.mu.Lock()
+		j.finishLocked(JobFailed, fmt.Errorf("load proxies failed: %w", err))
+		j.mu.Unlock()
+		return
+	}
+
+	j.mu.Lock()
+	j.Total = len(proxies)
+	j.Metadata = tester.Metadata()
+	j.notifyLocked()
+	j.mu.Unlock()
+
+	err = tester.TestProxiesContext(ctx, proxies, func(result *speedtester.Result) {
+		j.mu.Lock()
+		j.results = append(j.results, result)
+		j.Done++
+		j.TrafficUsed = tester.TrafficUsed()
+		j.notifyLocked()
+		j.mu.Unlock()
+	})
+
+	j.mu.Lock()
+	j.TrafficUsed = tester.TrafficUsed()
+	if err != nil {
+		j.finishLocked(JobCanceled, nil)
+	} else {
+		j.finishLocked(JobCompleted, nil)
+	}
+	j.mu.Unlock()
+}
+
+// Cancel 取消任务。排队中的任务直接结束，运行中的任务不再开始新的节点测试
+func (j *Job) Cancel() {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	switch j.Status {
+	case JobQueued:
+		j.finishLocked(JobCanceled, nil)
+	case JobRunning:
+		j.cancel()
+	}
+}
+
+// jobQueue 按提交顺序执行任务，同时运行的任务数不超过 workers
+type jobQueue struct {
+	mu      sync.Mutex
+	jobs    map[string]*Job
+	order   []string
+	pending chan *Job
+	ctx     context.Context
+	history *history.Store
+	metrics *metrics.Registry
+}
+
+func newJobQueue(ctx context.Context, workers, size int, store *history.Store, registry *metrics.Registry) *jobQueue {
+	q := &jobQueue{
+		jobs:    make(map[string]*Job),
+		pending: make(chan *Job, size),
+		ctx:     ctx,
+		history: store,
+		metrics: registry,
+	}
+	for i := 0; i < workers; i++ {
+		go q.work()
+	}
+	return q
+}
+
+func (q *jobQueue) work() {
+	for {
+		select {
+		case job := <-q.pending:
+			job.run(q.ctx)
+			q.save(job)
+		case <-q.ctx.Done():
+			return
+		}
+	}
+}
+
+// save 将完成的任务结果保存到历史记录并更新指标，profile 记为 api
+func (q *jobQueue) save(job *Job) {
+	results := job.Results()
+	if results.Status != JobCompleted {
+		return
+	}
+	if q.history != nil {
+		if err := q.history.SaveRun("api", *results.StartedAt, results.Results); err != nil {
+			log.Printf("save job %s to history failed: %v", job.ID, err)
+		}
+	}
+	if q.metrics != nil {
+		duration := results.FinishedAt.Sub(*results.StartedAt)
+		if err := q.metrics.Observe("api", *results.StartedAt, duration, results.Results, results.TrafficUsed); err != nil {
+			log.Printf("write metrics of job %s failed: %v", job.ID, err)
+		}
+	}
+}
+
+// Submit 将任务加入队列，队列已满时返回 ErrQueueFull
+func (q *jobQueue) Submit(job *Job) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	select {
+	case q.pending <- job:
+	default:
+		return ErrQueueFull
+	}
+	q.jobs[job.ID] = job
+	q.order = append(q.order, job.ID)
+	q.pruneLocked()
+	return nil
+}
+
+// pruneLocked 任务数超过 maxJobHistory 时按提交顺序删除已经结束的任务
+func (q *jobQueue) pruneLocked() {
+	for i := 0; i < len(q.order) && len(q.order) > maxJobHistory; {
+		job := q.jobs[q.order[i]]
+		if !job.snapshot().Status.Finished() {
+			i++
+			continue
+		}
+		delete(q.jobs, job.ID)
+		q.order = append(q.order[:i], q.order[i+1:]...)
+	}
+}
+
+func (q *jobQueue) Get(id string) (*Job, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	job, ok := q.jobs[id]
+	if !ok {
+		return nil, ErrJobNotFound
+	}
+	return job, nil
+}
+
+// List 按提交顺序返回所有任务的状态
+func (q *jobQueue) List() []*Job {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	jobs := make([]*Job, 0, len(q.order))
+	for _, id := range q.order {
+		jobs = append(jobs, q.jobs[id].snapshot())
+	}
+	return jobs
+}
+
+// Latest 返回最近完成的任务的测试结果，没有已完成的任务时返回 nil
+func (q *jobQueue) Latest() []*speedtester.Result {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	var latest *JobResults
+	for _, id := range q.order {
+		results := q.jobs[id].Results()
+		if results.Status != JobCompleted {
+			continue
+		}
+		if latest == nil || results.FinishedAt.After(*latest.FinishedAt) {
+			latest = results
+		}
+	}
+	if latest == nil {
+		return nil
+	}
+	return latest.Results
+}
@@ -0,0 +1,108 @@
+package apiserver
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/faceair/clash-speedtest/speedtester"
+	"github.com/faceair/clash-speedtest/subscription"
+)
+
+// handleSubscription 根据最近的测试结果生成订阅，例如
+// /sub?format=clash&country=JP&min-download=5&limit=20
+//
+// 有历史记录时使用每个节点在 since（默认最近一天）内最近一次的结果，否则使用最近完成的任务的结果
+func (s *Server) handleSubscription(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	options := s.config.Subscription
+	if err := parseSubscriptionQuery(query.Get, &options); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	var results []*speedtester.Result
+	if s.config.History != nil {
+		now := time.Now()
+		since, err := parseHistoryTime(query.Get("since"), now, now.Add(-defaultHistoryWindow))
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+		results, err = s.config.History.Latest(since)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+			return
+		}
+	} else {
+		results = s.queue.Latest()
+	}
+	if len(results) == 0 {
+		writeError(w, http.StatusNotFound, errors.New("no test results yet"))
+		return
+	}
+
+	// 结果中没有速度时（Fast 模式的任务）按延迟排序，且不根据速度过滤
+	if !options.Fast {
+		options.Fast = true
+		for _, result := range results {
+			if result.DownloadSpeed > 0 || result.UploadSpeed > 0 {
+				options.Fast = false
+				break
+			}
+		}
+	}
+	subscription.Sort(results, options.Fast)
+
+	format := query.Get("format")
+	data, err := subscription.Generate(results, &options, format)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	if format == subscription.FormatClash {
+		w.Header().Set("Content-Type", "text/yaml; charset=utf-8")
+	} else {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	}
+	w.Write(data)
+}
+
+// parseSubscriptionQuery 用查询参数覆盖默认的订阅参数，速度单位为 MB/s
+func parseSubscriptionQuery(get func(string) string, options *subscription.Options) error {
+	if v := get("country"); v != "" {
+		options.Country = v
+	}
+	if v := get("rename"); v != "" {
+		options.RenameMode = v
+	}
+	for name, target := range map[string]*float64{
+		"min-download": &options.MinDownloadSpeed,
+		"min-upload":   &options.MinUploadSpeed,
+	} {
+		if v := get(name); v != "" {
+			f, err := strconv.ParseFloat(v, 64)
+			if err != nil {
+				return fmt.Errorf("invalid %s: %s", name, v)
+			}
+			*target = f
+		}
+	}
+	if v := get("max-latency"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return fmt.Errorf("invalid max-latency: %s", v)
+		}
+		options.MaxLatency = d
+	}
+	if v := get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return fmt.Errorf("invalid limit: %s", v)
+		}
+		options.Limit = n
+	}
+	return nil
+}
@@ -0,0 +1,171 @@
+package daemon
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/faceair/clash-speedtest/apiserver"
+	"github.com/faceair/clash-speedtest/history"
+	"github.com/faceair/clash-speedtest/metrics"
+	"github.com/faceair/clash-speedtest/speedtester"
+	"gopkg.in/yaml.v3"
+)
+
+type Config struct {
+	// 历史记录的保留时间，0 表示永久保留
+	Retention time.Duration `yaml:"retention"`
+	Profiles  []*Profile    `yaml:"profiles"`
+}
+
+// Profile 是按计划运行的测试配置，测试参数与 serve 子命令提交任务的参数相同
+type Profile struct {
+	Name string `yaml:"name"`
+	// cron 表达式，例如 "*/30 * * * *" 或 "@every 1h"
+	Schedule string `yaml:"schedule"`
+
+	apiserver.JobRequest `yaml:",inline"`
+}
+
+func LoadConfig(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	config := &Config{}
+	if err := yaml.Unmarshal(data, config); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// Daemon 按计划运行各个测试配置，并将结果保存到历史记录中
+type Daemon struct {
+	config    *Config
+	defaults  speedtester.Config
+	store     *history.Store
+	metrics   *metrics.Registry
+	schedules map[string]Schedule
+	// 同一时间只运行一个配置，避免多个配置同时测速互相影响
+	mu sync.Mutex
+}
+
+// New 创建守护进程，registry 不为空时每次运行后更新指标
+func New(config *Config, defaults speedtester.Config, store *history.Store, registry *metrics.Registry) (*Daemon, error) {
+	if len(config.Profiles) == 0 {
+		return nil, fmt.Errorf("no profiles configured")
+	}
+	d := &Daemon{
+		config:    config,
+		defaults:  defaults,
+		store:     store,
+		metrics:   registry,
+		schedules: make(map[string]Schedule),
+	}
+	for _, profile := range config.Profiles {
+		if profile.Name == "" {
+			return nil, fmt.Errorf("profile name is required")
+		}
+		if _, exist := d.schedules[profile.Name]; exist {
+			return nil, fmt.Errorf("profile %s is the duplicate name", profile.Name)
+		}
+		schedule, err := ParseSchedule(profile.Schedule)
+		if err != nil {
+			return nil, fmt.Errorf("profile %s: %w", profile.Name, err)
+		}
+		if _, err := profile.BuildConfig(defaults); err != nil {
+			return nil, fmt.Errorf("profile %s: %w", profile.Name, err)
+		}
+		d.schedules[profile.Name] = schedule
+	}
+	return d, nil
+}
+
+// Run 按计划运行所有配置，直到 context 结束
+func (d *Daemon) Run(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, profile := range d.config.Profiles {
+		wg.Add(1)
+		go func(profile *Profile) {
+			defer wg.Done()
+			d.runProfile(ctx, profile)
+		}(profile)
+	}
+	wg.Wait()
+}
+
+func (d *Daemon) runProfile(ctx context.Context, profile *Profile) {
+	schedule := d.schedules[profile.Name]
+	for {
+		next := schedule.Next(time.Now())
+		if next.IsZero() {
+			log.Printf("profile %s: schedule %q never runs", profile.Name, profile.Schedule)
+			return
+		}
+		log.Printf("profile %s: next run at %s", profile.Name, next.Format(time.DateTime))
+
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		}
+		if err := d.RunOnce(ctx, profile); err != nil {
+			log.Printf("profile %s: run failed: %v", profile.Name, err)
+		}
+	}
+}
+
+// RunOnce 立即运行一次配置并保存结果，被取消的运行不会保存
+func (d *Daemon) RunOnce(ctx context.Context, profile *Profile) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	config, err := profile.BuildConfig(d.defaults)
+	if err != nil {
+		return err
+	}
+	tester := speedtester.New(config)
+	proxies, err := tester.LoadProxies()
+	if err != nil {
+		return fmt.Errorf("load proxies failed: %w", err)
+	}
+
+	start := time.Now()
+	results := make([]*speedtester.Result, 0, len(proxies))
+	err = tester.TestProxiesContext(ctx, proxies, func(result *speedtester.Result) {
+		results = append(results, result)
+	})
+	if err != nil {
+		return err
+	}
+	if err := d.store.SaveRun(profile.Name, start, results); err != nil {
+		return fmt.Errorf("save results failed: %w", err)
+	}
+	if d.config.Retention > 0 {
+		if err := d.store.Prune(time.Now().Add(-d.config.Retention)); err != nil {
+			return fmt.Errorf("prune history failed: %w", err)
+		}
+	}
+
+	duration := time.Since(start)
+	if d.metrics != nil {
+		if err := d.metrics.Observe(profile.Name, start, duration, results, tester.TrafficUsed()); err != nil {
+			log.Printf("profile %s: write metrics failed: %v", profile.Name, err)
+		}
+	}
+
+	up := 0
+	for _, result := range results {
+		if history.IsUp(result) {
+			up++
+		}
+	}
+	log.Printf("profile %s: %d/%d proxies up, took %s, traffic %s", profile.Name, up, len(results),
+		duration.Round(time.Second), speedtester.FormatSize(tester.TrafficUsed()))
+	return nil
+}
@@ -0,0 +1,156 @@
+package daemon
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Schedule 计算下一次运行的时间
+type Schedule interface {
+	Next(t time.Time) time.Time
+}
+
+// ParseSchedule 解析 cron 表达式，支持标准的5个字段（分 时 日 月 周）以及
+// @hourly、@daily、@weekly 和 @every <duration>
+func ParseSchedule(spec string) (Schedule, error) {
+	spec = strings.TrimSpace(spec)
+	switch spec {
+	case "@hourly":
+		spec = "0 * * * *"
+	case "@daily", "@midnight":
+		spec = "0 0 * * *"
+	case "@weekly":
+		spec = "0 0 * * 0"
+	}
+	if strings.HasPrefix(spec, "@every ") {
+		interval, err := time.ParseDuration(strings.TrimSpace(strings.TrimPrefix(spec, "@every ")))
+		if err != nil {
+			return nil, err
+		}
+		if interval < time.Minute {
+			return nil, fmt.Errorf("interval must be at least 1m: %s", spec)
+		}
+		return everySchedule(interval), nil
+	}
+
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("cron expression must have 5 fields: %s", spec)
+	}
+	ranges := [5][2]int{{0, 59}, {0, 23}, {1, 31}, {1, 12}, {0, 7}}
+	var sets [5]map[int]bool
+	for i, field := range fields {
+		set, err := parseCronField(field, ranges[i][0], ranges[i][1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid cron field %q: %w", field, err)
+		}
+		sets[i] = set
+	}
+	// 周日可以写作 0 或 7
+	if sets[4][7] {
+		sets[4][0] = true
+	}
+	delete(sets[4], 7)
+	return &cronSchedule{
+		minutes:  sets[0],
+		hours:    sets[1],
+		days:     sets[2],
+		months:   sets[3],
+		weekdays: sets[4],
+		// 与 Vixie cron 一样按字段的写法判断，以 * 开头（包括 */2）的字段视为未限制
+		anyDay:  strings.HasPrefix(fields[2], "*"),
+		anyWeek: strings.HasPrefix(fields[4], "*"),
+	}, nil
+}
+
+// parseCronField 解析逗号分隔的 *、*/n、a、a/n、a-b、a-b/n，a/n 表示从 a 开始到最大值每隔 n
+func parseCronField(field string, min, max int) (map[int]bool, error) {
+	set := make(map[int]bool)
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		i := strings.Index(part, "/")
+		if i >= 0 {
+			var err error
+			step, err = strconv.Atoi(part[i+1:])
+			if err != nil || step <= 0 {
+				return nil, fmt.Errorf("invalid step: %s", part)
+			}
+			part = part[:i]
+		}
+
+		start, end := min, max
+		if part != "*" {
+			bounds := strings.SplitN(part, "-", 2)
+			var err error
+			start, err = strconv.Atoi(bounds[0])
+			if err != nil {
+				return nil, err
+			}
+			end = start
+			if len(bounds) == 1 && i >= 0 {
+				end = max
+			}
+			if len(bounds) == 2 {
+				end, err = strconv.Atoi(bounds[1])
+				if err != nil {
+					return nil, err
+				}
+			}
+		}
+		if start < min || end > max || start > end {
+			return nil, fmt.Errorf("out of range [%d, %d]: %s", min, max, part)
+		}
+		for v := start; v <= end; v += step {
+			set[v] = true
+		}
+	}
+	return set, nil
+}
+
+type cronSchedule struct {
+	minutes, hours, days, months, weekdays map[int]bool
+	// 日和周都不以 * 开头时满足其一即可，否则需要同时满足，与 cron 的行为一致
+	anyDay, anyWeek bool
+}
+
+func (s *cronSchedule) Next(t time.Time) time.Time {
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	// 最多向后查找五年，足以覆盖 2 月 29 日这样的表达式
+	for limit := t.AddDate(5, 0, 0); t.Before(limit); {
+		if !s.months[int(t.Month())] {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if !s.matchDay(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if !s.hours[t.Hour()] {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+			continue
+		}
+		if !s.minutes[t.Minute()] {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
+
+func (s *cronSchedule) matchDay(t time.Time) bool {
+	day, week := s.days[t.Day()], s.weekdays[int(t.Weekday())]
+	if s.anyDay || s.anyWeek {
+		return day && week
+	}
+	return day || week
+}
+
+// everySchedule 按固定间隔运行
+type everySchedule time.Duration
+
+func (s everySchedule) Next(t time.Time) time.Time {
+	return t.Add(time.Duration(s))
+}
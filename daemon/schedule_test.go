@@ -0,0 +1,105 @@
+package daemon
+
+import (
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestParseCronField(t *testing.T) {
+	tests := []struct {
+		field   string
+		min     int
+		max     int
+		want    []int
+		wantErr bool
+	}{
+		{field: "*", min: 0, max: 6, want: []int{0, 1, 2, 3, 4, 5, 6}},
+		{field: "*/15", min: 0, max: 59, want: []int{0, 15, 30, 45}},
+		{field: "5", min: 0, max: 59, want: []int{5}},
+		{field: "5/15", min: 0, max: 59, want: []int{5, 20, 35, 50}},
+		{field: "5/1", min: 0, max: 10, want: []int{5, 6, 7, 8, 9, 10}},
+		{field: "1-5", min: 0, max: 59, want: []int{1, 2, 3, 4, 5}},
+		{field: "1-10/3", min: 0, max: 59, want: []int{1, 4, 7, 10}},
+		{field: "1,3,20-22", min: 0, max: 59, want: []int{1, 3, 20, 21, 22}},
+		{field: "60", min: 0, max: 59, wantErr: true},
+		{field: "5-1", min: 0, max: 59, wantErr: true},
+		{field: "*/0", min: 0, max: 59, wantErr: true},
+		{field: "a", min: 0, max: 59, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.field, func(t *testing.T) {
+			set, err := parseCronField(tt.field, tt.min, tt.max)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("parseCronField(%q) = %v, want error", tt.field, set)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseCronField(%q) error = %v", tt.field, err)
+			}
+			got := make([]int, 0, len(set))
+			for v := range set {
+				got = append(got, v)
+			}
+			sort.Ints(got)
+			if len(got) != len(tt.want) {
+				t.Fatalf("parseCronField(%q) = %v, want %v", tt.field, got, tt.want)
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Fatalf("parseCronField(%q) = %v, want %v", tt.field, got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestScheduleNext(t *testing.T) {
+	// 2024-01-01 是周一
+	base := time.Date(2024, 1, 1, 10, 30, 20, 0, time.UTC)
+	tests := []struct {
+		spec string
+		from time.Time
+		want time.Time
+	}{
+		{spec: "@hourly", from: base, want: time.Date(2024, 1, 1, 11, 0, 0, 0, time.UTC)},
+		{spec: "@daily", from: base, want: time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)},
+		{spec: "@weekly", from: base, want: time.Date(2024, 1, 7, 0, 0, 0, 0, time.UTC)},
+		{spec: "@every 30m", from: base, want: base.Add(30 * time.Minute)},
+		{spec: "*/15 * * * *", from: base, want: time.Date(2024, 1, 1, 10, 45, 0, 0, time.UTC)},
+		{spec: "40/10 * * * *", from: base, want: time.Date(2024, 1, 1, 10, 40, 0, 0, time.UTC)},
+		{spec: "0 3 * * 7", from: base, want: time.Date(2024, 1, 7, 3, 0, 0, 0, time.UTC)},
+		{spec: "0 0 29 2 *", from: base, want: time.Date(2024, 2, 29, 0, 0, 0, 0, time.UTC)},
+		// 日和周都指定时满足其一即可
+		{spec: "0 0 15 * 5", from: base, want: time.Date(2024, 1, 5, 0, 0, 0, 0, time.UTC)},
+		// 按写法而不是取值判断：1-31、0-6 不以 * 开头，满足其一即可，因此每天都会运行
+		{spec: "0 0 1-31 * 5", from: base, want: time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)},
+		{spec: "0 0 15 * 0-6", from: base, want: time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)},
+		{spec: "0 0 15 * 1-7", from: base, want: time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)},
+		// 以 * 开头的字段需要与另一个字段同时满足
+		{spec: "0 0 */1 * 3", from: base, want: time.Date(2024, 1, 3, 0, 0, 0, 0, time.UTC)},
+		{spec: "0 0 */2 * 1", from: base, want: time.Date(2024, 1, 15, 0, 0, 0, 0, time.UTC)},
+		{spec: "0 0 15 * */2", from: base, want: time.Date(2024, 2, 15, 0, 0, 0, 0, time.UTC)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			schedule, err := ParseSchedule(tt.spec)
+			if err != nil {
+				t.Fatalf("ParseSchedule(%q) error = %v", tt.spec, err)
+			}
+			if got := schedule.Next(tt.from); !got.Equal(tt.want) {
+				t.Errorf("Next() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseScheduleInvalid(t *testing.T) {
+	for _, spec := range []string{"", "* * * *", "@every 10s", "@every x", "0 24 * * *", "0 0 0 * *"} {
+		if _, err := ParseSchedule(spec); err == nil {
+			t.Errorf("ParseSchedule(%q) succeeded, want error", spec)
+		}
+	}
+}
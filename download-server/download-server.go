@@ -1,52 +1,80 @@
 package main
 
 import (
-	"fmt"
-	"io"
-	"net/http"
-	"strconv"
+	"flag"
+	"log"
 
-	"github.com/faceair/clash-speedtest/speedtester"
+	"github.com/faceair/clash-speedtest/speedserver"
 )
 
-func main() {
-	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
-		w.Header().Set("Content-Type", "text/html")
-		w.WriteHeader(http.StatusOK)
-		w.Write([]byte(`<h1>SpeedTest Server</h1>`))
-	})
+var (
+	configPath    = flag.String("config", "", "yaml config file, flags set explicitly override values in the file")
+	listen        = flag.String("listen", ":8080", "listen address of http(s) and udp echo")
+	tlsCert       = flag.String("tls-cert", "", "tls certificate file")
+	tlsKey        = flag.String("tls-key", "", "tls private key file")
+	tlsSelfSigned = flag.Bool("tls-self-signed", false, "serve https with an autogenerated self-signed certificate")
+	token         = flag.String("token", "", "require Authorization: Bearer <token> (or ?token=) on test endpoints")
+	maxBytes      = flag.Int64("max-bytes", 0, "max bytes of a single download or upload request, 0 means no limit")
+	payload       = flag.String("payload", "random", "payload of /__down: zero|random, random data can not be compressed by proxies")
+	udpEcho       = flag.Bool("udp-echo", true, "echo udp packets on the listen address for udp relay tests")
+	receiptKey    = flag.String("receipt-key", "", "sign upload receipts with HMAC-SHA256 using this key")
+	loc           = flag.String("loc", "", "country code returned as loc= by /cdn-cgi/trace, e.g. JP, XX if empty")
+	colo          = flag.String("colo", "", "data center code returned as colo= by /cdn-cgi/trace, e.g. NRT, XX if empty")
+)
 
-	http.HandleFunc("/__down", func(w http.ResponseWriter, r *http.Request) {
-		if r.Method != http.MethodGet {
-			w.WriteHeader(http.StatusMethodNotAllowed)
-			return
-		}
+func main() {
+	flag.Parse()
 
-		byteSize, err := strconv.Atoi(r.URL.Query().Get("bytes"))
+	config := &speedserver.Config{UDPEcho: true}
+	if *configPath != "" {
+		var err error
+		config, err = speedserver.LoadConfig(*configPath)
 		if err != nil {
-			w.WriteHeader(http.StatusBadRequest)
-			w.Write([]byte(err.Error()))
-			return
+			log.Fatalf("load config failed: %v", err)
 		}
+	}
 
-		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=speedtest-%d.bin", byteSize))
-		w.Header().Set("Content-Type", "application/octet-stream")
-		w.WriteHeader(http.StatusOK)
-
-		reader := speedtester.NewZeroReader(byteSize)
-		io.Copy(w, reader)
-	})
-
-	http.HandleFunc("/__up", func(w http.ResponseWriter, r *http.Request) {
-		if r.Method != http.MethodPost {
-			w.WriteHeader(http.StatusMethodNotAllowed)
-			return
-		}
-
-		io.Copy(io.Discard, r.Body)
-
-		w.WriteHeader(http.StatusOK)
-	})
+	// 命令行中显式指定的参数覆盖配置文件
+	explicit := make(map[string]bool)
+	flag.Visit(func(f *flag.Flag) { explicit[f.Name] = true })
+	if *configPath == "" || explicit["listen"] {
+		config.Listen = *listen
+	}
+	if *configPath == "" || explicit["tls-cert"] {
+		config.TLSCert = *tlsCert
+	}
+	if *configPath == "" || explicit["tls-key"] {
+		config.TLSKey = *tlsKey
+	}
+	if *configPath == "" || explicit["tls-self-signed"] {
+		config.TLSSelfSigned = *tlsSelfSigned
+	}
+	if *configPath == "" || explicit["token"] {
+		config.Token = *token
+	}
+	if *configPath == "" || explicit["max-bytes"] {
+		config.MaxBytes = *maxBytes
+	}
+	if *configPath == "" || explicit["payload"] {
+		config.Payload = *payload
+	}
+	if *configPath == "" || explicit["udp-echo"] {
+		config.UDPEcho = *udpEcho
+	}
+	if *configPath == "" || explicit["receipt-key"] {
+		config.ReceiptKey = *receiptKey
+	}
+	if *configPath == "" || explicit["loc"] {
+		config.Loc = *loc
+	}
+	if *configPath == "" || explicit["colo"] {
+		config.Colo = *colo
+	}
 
-	http.ListenAndServe(":8080", nil)
+	server, err := speedserver.New(config)
+	if err != nil {
+		log.Fatalf("create server failed: %v", err)
+	}
+	log.Printf("speedtest server listening on %s", config.Listen)
+	log.Fatal(server.ListenAndServe())
 }
@@ -4,9 +4,11 @@ go 1.23
 
 require (
 	github.com/andybalholm/brotli v1.0.6
+	github.com/gobwas/ws v1.4.0
 	github.com/metacubex/mihomo v1.19.10
 	github.com/olekukonko/tablewriter v0.0.5
 	github.com/schollz/progressbar/v3 v3.17.0
+	go.etcd.io/bbolt v1.4.3
 	gopkg.in/yaml.v3 v3.0.1
 )
 
@@ -15,6 +17,7 @@ require (
 	github.com/ebitengine/purego v0.8.4 // indirect
 	github.com/enfein/mieru/v3 v3.13.0 // indirect
 	github.com/metacubex/bart v0.20.5 // indirect
+	github.com/metacubex/bbolt v0.0.0-20240822011022-aed6d4850399 // indirect
 	github.com/metacubex/fswatch v0.1.1 // indirect
 	github.com/metacubex/sing v0.5.3 // indirect
 	github.com/metacubex/sing-mux v0.3.2 // indirect
@@ -44,7 +47,6 @@ require (
 	github.com/go-task/slim-sprig v0.0.0-20230315185526-52ccab3ef572 // indirect
 	github.com/gobwas/httphead v0.1.0 // indirect
 	github.com/gobwas/pool v0.2.1 // indirect
-	github.com/gobwas/ws v1.4.0 // indirect
 	github.com/gofrs/uuid/v5 v5.3.2 // indirect
 	github.com/google/btree v1.1.3 // indirect
 	github.com/google/go-cmp v0.6.0 // indirect
@@ -60,7 +62,6 @@ require (
 	github.com/mdlayher/netlink v1.7.2 // indirect
 	github.com/mdlayher/socket v0.4.1 // indirect
 	github.com/metacubex/amneziawg-go v0.0.0-20240922133038-fdf3a4d5a4ab // indirect
-	github.com/metacubex/bbolt v0.0.0-20240822011022-aed6d4850399 // indirect
 	github.com/metacubex/chacha v0.1.2 // indirect
 	github.com/metacubex/gopacket v1.1.20-0.20230608035415-7e2f98a3e759 // indirect
 	github.com/metacubex/gvisor v0.0.0-20250324165734-5857f47bd43b // indirect
@@ -0,0 +1,334 @@
+package history
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/faceair/clash-speedtest/speedtester"
+	"go.etcd.io/bbolt"
+)
+
+var (
+	bucketNodes   = []byte("nodes")
+	bucketRecords = []byte("records")
+	bucketRuns    = []byte("runs")
+)
+
+var ErrNodeNotFound = errors.New("node not found")
+
+// Store 使用 bbolt 保存每次运行的测试结果，按节点指纹和时间索引
+type Store struct {
+	db *bbolt.DB
+}
+
+// Node 是出现过的节点，名称和类型为最近一次测试时的值
+type Node struct {
+	Fingerprint string    `json:"fingerprint"`
+	Name        string    `json:"name"`
+	Type        string    `json:"type"`
+	FirstSeen   time.Time `json:"first_seen"`
+	LastSeen    time.Time `json:"last_seen"`
+}
+
+// Record 是节点在某次运行中的完整测试结果
+type Record struct {
+	Time    time.Time           `json:"time"`
+	Profile string              `json:"profile"`
+	Result  *speedtester.Result `json:"result"`
+}
+
+// Run 是一次运行的概要
+type Run struct {
+	Time    time.Time `json:"time"`
+	Profile string    `json:"profile"`
+	Total   int       `json:"total"`
+	Up      int       `json:"up"`
+}
+
+// Point 是节点历史曲线中的一个点
+type Point struct {
+	Time          time.Time     `json:"time"`
+	Profile       string        `json:"profile"`
+	Up            bool          `json:"up"`
+	Latency       time.Duration `json:"latency"`
+	Jitter        time.Duration `json:"jitter"`
+	PacketLoss    float64       `json:"packet_loss"`
+	DownloadSpeed float64       `json:"download_speed"`
+	UploadSpeed   float64       `json:"upload_speed"`
+}
+
+// Uptime 是节点在一段时间内的可用率，延迟测试成功即视为可用
+type Uptime struct {
+	Fingerprint string  `json:"fingerprint"`
+	Runs        int     `json:"runs"`
+	Up          int     `json:"up"`
+	Percent     float64 `json:"percent"`
+}
+
+func Open(path string) (*Store, error) {
+	db, err := bbolt.Open(path, 0o600, &bbolt.Options{Timeout: time.Second})
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bbolt.Tx) error {
+		for _, name := range [][]byte{bucketNodes, bucketRecords, bucketRuns} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &Store{db: db}, nil
+}
+
+func (s *Store) Close() error {
+	return s.db.Close()
+}
+
+// Fingerprint 根据节点配置计算指纹，节点改名后仍能对应到同一条历史记录。
+// Proxy Provider 中的节点没有单独的配置，使用名称区分
+func Fingerprint(result *speedtester.Result) string {
+	fields := make(map[string]any, len(result.ProxyConfig))
+	for k, v := range result.ProxyConfig {
+		if k != "name" {
+			fields[k] = v
+		}
+	}
+	if _, ok := fields["server"]; !ok {
+		fields["name"] = result.ProxyName
+	}
+	// encoding/json 按键排序输出 map，相同配置得到相同的指纹
+	data, _ := json.Marshal(fields)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:8])
+}
+
+// IsUp 判断节点在该次测试中是否可用
+func IsUp(result *speedtester.Result) bool {
+	return result.Latency > 0
+}
+
+// SaveRun 保存一次运行的全部结果
+func (s *Store) SaveRun(profile string, at time.Time, results []*speedtester.Result) error {
+	key := timeKey(at)
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		nodes := tx.Bucket(bucketNodes)
+		records := tx.Bucket(bucketRecords)
+		run := &Run{Time: at, Profile: profile, Total: len(results)}
+		for _, result := range results {
+			fingerprint := Fingerprint(result)
+			if IsUp(result) {
+				run.Up++
+			}
+
+			node := &Node{Fingerprint: fingerprint, FirstSeen: at}
+			if data := nodes.Get([]byte(fingerprint)); data != nil {
+				if err := json.Unmarshal(data, node); err != nil {
+					return err
+				}
+			}
+			node.Name = result.ProxyName
+			node.Type = result.ProxyType
+			node.LastSeen = at
+			if err := putJSON(nodes, []byte(fingerprint), node); err != nil {
+				return err
+			}
+
+			bucket, err := records.CreateBucketIfNotExists([]byte(fingerprint))
+			if err != nil {
+				return err
+			}
+			if err := putJSON(bucket, key, &Record{Time: at, Profile: profile, Result: result}); err != nil {
+				return err
+			}
+		}
+		return putJSON(tx.Bucket(bucketRuns), key, run)
+	})
+}
+
+// Nodes 返回所有节点，name 不为空时只返回该名称的节点
+func (s *Store) Nodes(name string) ([]*Node, error) {
+	nodes := make([]*Node, 0)
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		return tx.Bucket(bucketNodes).ForEach(func(_, data []byte) error {
+			node := &Node{}
+			if err := json.Unmarshal(data, node); err != nil {
+				return err
+			}
+			if name == "" || node.Name == name {
+				nodes = append(nodes, node)
+			}
+			return nil
+		})
+	})
+	return nodes, err
+}
+
+// Records 返回节点在 [since, until) 内的完整测试结果，按时间排序
+func (s *Store) Records(fingerprint string, since, until time.Time) ([]*Record, error) {
+	records := make([]*Record, 0)
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		bucket := tx.Bucket(bucketRecords).Bucket([]byte(fingerprint))
+		if bucket == nil {
+			return ErrNodeNotFound
+		}
+		end := timeKey(until)
+		c := bucket.Cursor()
+		for k, data := c.Seek(timeKey(since)); k != nil && bytes.Compare(k, end) < 0; k, data = c.Next() {
+			record := &Record{}
+			if err := json.Unmarshal(data, record); err != nil {
+				return err
+			}
+			records = append(records, record)
+		}
+		return nil
+	})
+	return records, err
+}
+
+// History 返回节点在 [since, until) 内的延迟和速度历史
+func (s *Store) History(fingerprint string, since, until time.Time) ([]*Point, error) {
+	records, err := s.Records(fingerprint, since, until)
+	if err != nil {
+		return nil, err
+	}
+	points := make([]*Point, 0, len(records))
+	for _, record := range records {
+		points = append(points, &Point{
+			Time:          record.Time,
+			Profile:       record.Profile,
+			Up:            IsUp(record.Result),
+			Latency:       record.Result.Latency,
+			Jitter:        record.Result.Jitter,
+			PacketLoss:    record.Result.PacketLoss,
+			DownloadSpeed: record.Result.DownloadSpeed,
+			UploadSpeed:   record.Result.UploadSpeed,
+		})
+	}
+	return points, nil
+}
+
+// Latest 返回 since 之后出现过的每个节点最近一次的测试结果
+func (s *Store) Latest(since time.Time) ([]*speedtester.Result, error) {
+	results := make([]*speedtester.Result, 0)
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		records := tx.Bucket(bucketRecords)
+		return records.ForEachBucket(func(fingerprint []byte) error {
+			k, data := records.Bucket(fingerprint).Cursor().Last()
+			if k == nil || bytes.Compare(k, timeKey(since)) < 0 {
+				return nil
+			}
+			record := &Record{}
+			if err := json.Unmarshal(data, record); err != nil {
+				return err
+			}
+			results = append(results, record.Result)
+			return nil
+		})
+	})
+	return results, err
+}
+
+// Uptime 统计节点在 [since, until) 内的可用率
+func (s *Store) Uptime(fingerprint string, since, until time.Time) (*Uptime, error) {
+	records, err := s.Records(fingerprint, since, until)
+	if err != nil {
+		return nil, err
+	}
+	uptime := &Uptime{Fingerprint: fingerprint, Runs: len(records)}
+	for _, record := range records {
+		if IsUp(record.Result) {
+			uptime.Up++
+		}
+	}
+	if uptime.Runs > 0 {
+		uptime.Percent = float64(uptime.Up) / float64(uptime.Runs) * 100
+	}
+	return uptime, nil
+}
+
+// Runs 返回 [since, until) 内的运行概要
+func (s *Store) Runs(since, until time.Time) ([]*Run, error) {
+	runs := make([]*Run, 0)
+	err := s.db.View(func(tx *bbolt.Tx) error {
+		end := timeKey(until)
+		c := tx.Bucket(bucketRuns).Cursor()
+		for k, data := c.Seek(timeKey(since)); k != nil && bytes.Compare(k, end) < 0; k, data = c.Next() {
+			run := &Run{}
+			if err := json.Unmarshal(data, run); err != nil {
+				return err
+			}
+			runs = append(runs, run)
+		}
+		return nil
+	})
+	return runs, err
+}
+
+// Prune 删除 before 之前的记录，不再出现的节点一并删除
+func (s *Store) Prune(before time.Time) error {
+	end := timeKey(before)
+	deleteBefore := func(bucket *bbolt.Bucket) error {
+		c := bucket.Cursor()
+		for k, _ := c.First(); k != nil && bytes.Compare(k, end) < 0; k, _ = c.First() {
+			if err := c.Delete(); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	return s.db.Update(func(tx *bbolt.Tx) error {
+		if err := deleteBefore(tx.Bucket(bucketRuns)); err != nil {
+			return err
+		}
+		records := tx.Bucket(bucketRecords)
+		nodes := tx.Bucket(bucketNodes)
+		var empty [][]byte
+		err := records.ForEachBucket(func(fingerprint []byte) error {
+			bucket := records.Bucket(fingerprint)
+			if err := deleteBefore(bucket); err != nil {
+				return err
+			}
+			if k, _ := bucket.Cursor().First(); k == nil {
+				empty = append(empty, fingerprint)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for _, fingerprint := range empty {
+			if err := records.DeleteBucket(fingerprint); err != nil {
+				return err
+			}
+			if err := nodes.Delete(fingerprint); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// timeKey 以大端序的纳秒时间戳作为键，键的字节序与时间顺序一致
+func timeKey(t time.Time) []byte {
+	key := make([]byte, 8)
+	binary.BigEndian.PutUint64(key, uint64(t.UnixNano()))
+	return key
+}
+
+func putJSON(bucket *bbolt.Bucket, key []byte, value any) error {
+	data, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	return bucket.Put(key, data)
+}
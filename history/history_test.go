@@ -0,0 +1,224 @@
+package history
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/faceair/clash-speedtest/speedtester"
+)
+
+// openStore 在临时目录中创建历史记录
+func openStore(t *testing.T) *Store {
+	t.Helper()
+	store, err := Open(filepath.Join(t.TempDir(), "history.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+	return store
+}
+
+// node 构造一个测试结果，latency 为 0 表示不可用
+func node(name, server string, latency time.Duration) *speedtester.Result {
+	return &speedtester.Result{
+		ProxyName:   name,
+		ProxyType:   "Shadowsocks",
+		ProxyConfig: map[string]any{"name": name, "type": "ss", "server": server, "port": 443},
+		Latency:     latency,
+	}
+}
+
+func TestFingerprint(t *testing.T) {
+	provider := func(name string) *speedtester.Result {
+		return &speedtester.Result{ProxyName: name, ProxyConfig: map[string]any{"name": name}}
+	}
+	tests := []struct {
+		name string
+		a, b *speedtester.Result
+		same bool
+	}{
+		{name: "renamed node", a: node("HK 01", "1.1.1.1", 0), b: node("香港 01", "1.1.1.1", 0), same: true},
+		{name: "different server", a: node("HK 01", "1.1.1.1", 0), b: node("HK 01", "2.2.2.2", 0), same: false},
+		// Provider 中的节点没有 server，按名称区分
+		{name: "provider same name", a: provider("HK 01"), b: provider("HK 01"), same: true},
+		{name: "provider different name", a: provider("HK 01"), b: provider("HK 02"), same: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, b := Fingerprint(tt.a), Fingerprint(tt.b)
+			if (a == b) != tt.same {
+				t.Errorf("Fingerprint() = %s, %s, want same = %v", a, b, tt.same)
+			}
+		})
+	}
+}
+
+func TestRecordsAndRuns(t *testing.T) {
+	store := openStore(t)
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	hk := node("HK", "1.1.1.1", 100*time.Millisecond)
+	for i := 0; i < 4; i++ {
+		if err := store.SaveRun("default", base.Add(time.Duration(i)*time.Hour), []*speedtester.Result{hk}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name         string
+		since, until time.Time
+		want         int
+	}{
+		{name: "all", since: base, until: base.Add(4 * time.Hour), want: 4},
+		// since 包含在内，until 不包含在内
+		{name: "half open", since: base.Add(time.Hour), until: base.Add(3 * time.Hour), want: 2},
+		{name: "empty", since: base.Add(5 * time.Hour), until: base.Add(6 * time.Hour), want: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			records, err := store.Records(Fingerprint(hk), tt.since, tt.until)
+			if err != nil {
+				t.Fatal(err)
+			}
+			runs, err := store.Runs(tt.since, tt.until)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(records) != tt.want || len(runs) != tt.want {
+				t.Fatalf("records = %d, runs = %d, want %d", len(records), len(runs), tt.want)
+			}
+			if tt.want > 0 && !records[0].Time.Equal(tt.since) {
+				t.Errorf("first record at %s, want %s", records[0].Time, tt.since)
+			}
+		})
+	}
+
+	if _, err := store.Records("unknown", base, base.Add(time.Hour)); err != ErrNodeNotFound {
+		t.Errorf("Records(unknown) error = %v, want %v", err, ErrNodeNotFound)
+	}
+}
+
+func TestLatest(t *testing.T) {
+	store := openStore(t)
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	runs := []struct {
+		at      time.Time
+		results []*speedtester.Result
+	}{
+		{at: base, results: []*speedtester.Result{node("HK", "1.1.1.1", 300*time.Millisecond), node("JP", "2.2.2.2", 50*time.Millisecond)}},
+		// HK 改名后仍是同一个节点，JP 之后不再出现
+		{at: base.Add(time.Hour), results: []*speedtester.Result{node("香港", "1.1.1.1", 100*time.Millisecond)}},
+	}
+	for _, run := range runs {
+		if err := store.SaveRun("default", run.at, run.results); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name  string
+		since time.Time
+		want  map[string]time.Duration
+	}{
+		{name: "all nodes", since: base, want: map[string]time.Duration{"香港": 100 * time.Millisecond, "JP": 50 * time.Millisecond}},
+		{name: "recent nodes", since: base.Add(time.Minute), want: map[string]time.Duration{"香港": 100 * time.Millisecond}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			results, err := store.Latest(tt.since)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := make(map[string]time.Duration, len(results))
+			for _, result := range results {
+				got[result.ProxyName] = result.Latency
+			}
+			if len(got) != len(tt.want) {
+				t.Fatalf("Latest() = %v, want %v", got, tt.want)
+			}
+			for name, latency := range tt.want {
+				if got[name] != latency {
+					t.Errorf("Latest()[%s] = %s, want %s", name, got[name], latency)
+				}
+			}
+		})
+	}
+}
+
+func TestUptime(t *testing.T) {
+	store := openStore(t)
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	// 四次运行中一次延迟测试失败
+	for i, latency := range []time.Duration{100 * time.Millisecond, 0, 120 * time.Millisecond, 90 * time.Millisecond} {
+		result := node("HK", "1.1.1.1", latency)
+		if err := store.SaveRun("default", base.Add(time.Duration(i)*time.Hour), []*speedtester.Result{result}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name         string
+		since, until time.Time
+		want         Uptime
+	}{
+		{name: "all runs", since: base, until: base.Add(4 * time.Hour), want: Uptime{Runs: 4, Up: 3, Percent: 75}},
+		{name: "down run only", since: base.Add(time.Hour), until: base.Add(2 * time.Hour), want: Uptime{Runs: 1, Up: 0, Percent: 0}},
+		{name: "no runs", since: base.Add(5 * time.Hour), until: base.Add(6 * time.Hour), want: Uptime{}},
+	}
+	fingerprint := Fingerprint(node("HK", "1.1.1.1", 0))
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			uptime, err := store.Uptime(fingerprint, tt.since, tt.until)
+			if err != nil {
+				t.Fatal(err)
+			}
+			tt.want.Fingerprint = fingerprint
+			if *uptime != tt.want {
+				t.Errorf("Uptime() = %+v, want %+v", *uptime, tt.want)
+			}
+		})
+	}
+}
+
+func TestPrune(t *testing.T) {
+	store := openStore(t)
+	base := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	hk := node("HK", "1.1.1.1", 100*time.Millisecond)
+	jp := node("JP", "2.2.2.2", 100*time.Millisecond)
+	if err := store.SaveRun("default", base, []*speedtester.Result{hk, jp}); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.SaveRun("default", base.Add(time.Hour), []*speedtester.Result{hk}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := store.Prune(base.Add(time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+
+	// JP 只在被删除的运行中出现，节点和记录一并删除
+	if _, err := store.Records(Fingerprint(jp), base, base.Add(2*time.Hour)); err != ErrNodeNotFound {
+		t.Errorf("Records(JP) error = %v, want %v", err, ErrNodeNotFound)
+	}
+	nodes, err := store.Nodes("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(nodes) != 1 || nodes[0].Name != "HK" {
+		t.Errorf("Nodes() = %+v, want only HK", nodes)
+	}
+	records, err := store.Records(Fingerprint(hk), base, base.Add(2*time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 1 || !records[0].Time.Equal(base.Add(time.Hour)) {
+		t.Errorf("Records(HK) = %d records, want the run after the prune time", len(records))
+	}
+	runs, err := store.Runs(base, base.Add(2*time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(runs) != 1 {
+		t.Errorf("Runs() = %d, want 1", len(runs))
+	}
+}
@@ -1,42 +1,97 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
 	"net/url"
 	"os"
+	"os/signal"
 	"sort"
+	"strconv"
 	"strings"
+	"syscall"
 	"time"
 
-	"github.com/faceair/clash-speedtest/utils"
-
-	"github.com/faceair/clash-speedtest/proxylink"
+	"github.com/faceair/clash-speedtest/apiserver"
+	"github.com/faceair/clash-speedtest/daemon"
+	"github.com/faceair/clash-speedtest/history"
+	"github.com/faceair/clash-speedtest/metrics"
 	"github.com/faceair/clash-speedtest/speedtester"
+	"github.com/faceair/clash-speedtest/subscription"
 	"github.com/metacubex/mihomo/log"
 	"github.com/olekukonko/tablewriter"
 	"github.com/schollz/progressbar/v3"
+	"gopkg.in/yaml.v3"
 )
 
 var (
 	configPathsConfig = flag.String("c", "", "config file path, also support http(s) url")
 	filterRegexConfig = flag.String("f", ".+", "filter proxies by name, use regexp")
 	serverURL         = flag.String("server-url", "https://speed.cloudflare.com", "server url")
+	speedBackend      = flag.String("backend", "cloudflare", "speed test backend: cloudflare|librespeed (server-url is the directory of garbage.php)|static (server-url is a large file, download only)|download-server")
+	latencyURL        = flag.String("latency-url", "https://www.gstatic.com/generate_204", "url for latency tests, e.g. the /generate_204 endpoint of a self-hosted download-server")
+	serverToken       = flag.String("server-token", "", "bearer token sent to the speed test server")
+	receiptKey        = flag.String("receipt-key", "", "verify upload receipts signed by download-server with this HMAC key")
+	insecure          = flag.Bool("insecure", false, "skip tls certificate verification, for self-hosted servers with self-signed certificates")
+	speedServers      = flag.String("servers", "", "speed test server urls separated by comma, each proxy uses the nearest one, overrides server-url")
+	serverMapPath     = flag.String("server-map", "", "yaml file mapping exit country codes to speed test server urls, e.g. US: https://us.example.com")
+	payload           = flag.String("payload", "random", "upload payload: zero|random, random data can not be compressed by proxies or middleboxes")
 	downloadSize      = flag.Int("download-size", 50*1024*1024, "download size for testing proxies")
+	downloadDuration  = flag.Duration("download-duration", 0, "test download for a fixed duration instead of a fixed size, e.g. 10s, 0 means disabled")
+	downloadWarmup    = flag.Duration("download-warmup", 2*time.Second, "warm-up window dropped from duration-based download results")
 	uploadSize        = flag.Int("upload-size", 20*1024*1024, "upload size for testing proxies")
+	soakDuration      = flag.Duration("soak-duration", 0, "run a sustained download for this duration to detect throttling, 0 means disabled")
+	soakSize          = flag.Int64("soak-size", 0, "run a sustained download until this many bytes are received to detect throttling, 0 means disabled")
+	rejectThrottled   = flag.Bool("reject-throttled", false, "filter proxies that are throttled during the sustained download test")
 	timeout           = flag.Duration("timeout", time.Second*5, "timeout for testing proxies")
 	concurrent        = flag.Int("concurrent", 4, "download concurrent size")
+	scalingStreams    = flag.String("scaling", "", "test download speed with each of these stream counts, e.g. 1,2,4,8")
 	testConcurrent    = flag.Int("test-concurrent", 2, "test proxies concurrent size")
+	rounds            = flag.Int("rounds", 1, "test each proxy this many times and use the median of each metric")
+	roundInterval     = flag.Duration("round-interval", 0, "interval between rounds of the same proxy")
+	maxVariation      = flag.Float64("max-variation", 0.3, "flag proxies whose coefficient of variation across rounds is greater than this value as unstable")
+	maxStreams        = flag.Int("max-streams", 0, "max download/upload streams across all proxies, 0 means no limit")
+	trafficBudget     = flag.String("traffic-budget", "", "max total traffic through the proxies of the whole run including udp and jitter tests, e.g. 20GB, remaining proxies fall back to fast mode once exhausted")
 	outputPath        = flag.String("output", "result.txt", "output config file path")
 	maxLatency        = flag.Duration("max-latency", 800*time.Millisecond, "filter latency greater than this value")
 	minDownloadSpeed  = flag.Float64("min-download-speed", 5, "filter speed less than this value(unit: MB/s)")
 	minUploadSpeed    = flag.Float64("min-upload-speed", 0, "filter upload speed less than this value(unit: MB/s)")
-	maxPacketLoss     = flag.Float64("max-packet-loss", 0, "filter packet loss greater than this value(unit: %)")
+	maxPacketLoss     = flag.Float64("max-packet-loss", 0, "filter UDP packet loss greater than this value, only applies to nodes that replied to the UDP test, speed tests are skipped for these nodes when greater than 0(unit: %)")
+	maxFailureRate    = flag.Float64("max-failure-rate", 0, "filter latency test request failure rate greater than this value, speed tests are skipped for these nodes when greater than 0(unit: %)")
 	limit             = flag.Int("limit", 0, "limit the number of proxies in output file, 0 means no limit")
 	unlockTest        = flag.String("unlock", "", "test streaming media unlock, support: netflix|chatgpt|disney|youtube|all")
 	fastMode          = flag.Bool("fast", false, "only test latency, skip download and upload speed test")
-	sortFields        = flag.String("sort", "weighted", "sort proxies by fields, support: latency|jitter|packet_loss|download|upload|weighted, multiple fields separated by comma, e.g. download,upload")
+	udpTest           = flag.Bool("udp", false, "test UDP relay by sending packets to the udp server through the proxy")
+	udpServer         = flag.String("udp-server", "1.1.1.1:53", "udp server for testing UDP relay, port 53 sends DNS queries, other ports expect an echo server")
+	jitterURL         = flag.String("jitter-url", "", "echo server for the jitter test, ws(s)://host/__ws or udp://host:port of a download-server")
+	jitterDuration    = flag.Duration("jitter-duration", 0, "send timestamped pings over one long-lived connection for this duration to measure RFC 3550 jitter, 0 means disabled")
+	jitterInterval    = flag.Duration("jitter-interval", 20*time.Millisecond, "interval between pings of the jitter test")
+	cnCheck           = flag.String("cn-check", "tcp", "china reachability checker: tcp (direct tcp connect from this machine)|api (third-party http api)|none")
+	cnCheckAPI        = flag.String("cn-check-api", "https://api.ycwxgzs.com/ipcheck/index.php", "checker api url for -cn-check api, supports {ip} and {port} placeholders")
+	cnCheckMethod     = flag.String("cn-check-method", "POST", "checker api method, POST sends ip and port as multipart form")
+	cnCheckField      = flag.String("cn-check-field", "tcp", "json field of the checker api response holding the result")
+	cnCheckBlocked    = flag.String("cn-check-blocked", "不可用", "the node is blocked if the result field contains this value")
+	dnsServers        = flag.String("dns", "", "resolve proxy server hostnames with these dns servers instead of the system dns, support https://, tls://, quic://, tcp://, udp:// and plain ip, separated by comma")
+	expandDNS         = flag.Bool("expand-dns", false, "test each A/AAAA record of the proxy server hostname as a separate proxy")
+	via               = flag.String("via", "", "dial every tested proxy through this relay proxy, a proxy name in the config or a config file/subscription containing relay proxies")
+	viaMatrix         = flag.Bool("via-matrix", false, "test every relay and landing pair of -via and print a matrix of latency and speed")
+	baseline          = flag.Bool("baseline", false, "run the latency and speed tests once without proxy before testing proxies as a baseline")
+	routeCheck        = flag.Bool("route-check", false, "look up geo/ASN of the entry server and the exit ip via ipinfo.io to detect relayed and transit proxies, sends the server ips to ipinfo.io")
+	onlyRelayed       = flag.Bool("only-relayed", false, "only keep relayed and transit proxies in output file, implies -route-check")
+	entryProbe        = flag.Bool("entry-probe", false, "dial the proxy server directly to measure entry rtt and inspect its tls certificate")
+	loadedLatency     = flag.Bool("loaded-latency", false, "measure latency under load during download and upload tests (bufferbloat)")
+	sortFields        = flag.String("sort", "weighted", "sort proxies by fields, support: latency|jitter|packet_loss|failure_rate|download|upload|weighted, multiple fields separated by comma, e.g. download,upload")
 	renameMode        = flag.String("rename", "overwrite", "rename mode for proxy names: add|overwrite|none")
+	listen            = flag.String("listen", "127.0.0.1:8090", "listen address of the serve subcommand, -api-token is required on non-loopback addresses")
+	apiToken          = flag.String("api-token", "", "require Authorization: Bearer <token> (or ?token=) on the api of the serve subcommand")
+	apiConfigDir      = flag.String("api-config-dir", "", "directory of config files that jobs of the serve api may read, only http(s) config_paths are accepted if empty")
+	maxJobs           = flag.Int("max-jobs", 1, "max jobs running at the same time in the serve subcommand")
+	maxQueuedJobs     = flag.Int("max-queued-jobs", 16, "max jobs waiting in the queue of the serve subcommand")
+	historyDB         = flag.String("history-db", "", "bbolt database storing results of the serve and daemon subcommands, daemon defaults to history.db")
+	daemonConfig      = flag.String("daemon-config", "daemon.yaml", "yaml file of the test profiles and their cron schedules for the daemon subcommand")
+	metricsFile       = flag.String("metrics-file", "", "write prometheus metrics to this file for the node_exporter textfile collector, serve and daemon subcommands rewrite it after every run")
+	subToken          = flag.String("sub-token", "", "token accepted only by the /sub subscription endpoint of the serve and daemon subcommands, -api-token is also accepted, /sub is disabled if neither is set")
 )
 
 const (
@@ -51,33 +106,33 @@ const (
 func calculateWeightedScore(results []*speedtester.Result, index int) float64 {
 	// 根据是否为Fast模式定义不同的权重
 	var (
-		latencyWeight    float64
-		jitterWeight     float64
-		packetLossWeight float64
-		downloadWeight   float64
-		uploadWeight     float64
+		latencyWeight     float64
+		jitterWeight      float64
+		failureRateWeight float64
+		downloadWeight    float64
+		uploadWeight      float64
 	)
 
 	if *fastMode {
-		// Fast模式下只考虑延迟、抖动和丢包率
-		latencyWeight = 0.60    // 延迟权重
-		jitterWeight = 0.20     // 抖动权重
-		packetLossWeight = 0.20 // 丢包率权重
-		downloadWeight = 0      // 下载速度权重
-		uploadWeight = 0        // 上传速度权重
+		// Fast模式下只考虑延迟、抖动和请求失败率
+		latencyWeight = 0.60     // 延迟权重
+		jitterWeight = 0.20      // 抖动权重
+		failureRateWeight = 0.20 // 请求失败率权重
+		downloadWeight = 0       // 下载速度权重
+		uploadWeight = 0         // 上传速度权重
 	} else {
 		// 正常模式下考虑所有指标
-		latencyWeight = 0.35    // 延迟权重
-		jitterWeight = 0.15     // 抖动权重
-		packetLossWeight = 0.15 // 丢包率权重
-		downloadWeight = 0.30   // 下载速度权重
-		uploadWeight = 0.05     // 上传速度权重
+		latencyWeight = 0.35     // 延迟权重
+		jitterWeight = 0.15      // 抖动权重
+		failureRateWeight = 0.15 // 请求失败率权重
+		downloadWeight = 0.30    // 下载速度权重
+		uploadWeight = 0.05      // 上传速度权重
 	}
 
 	// 创建各项指标的得分映射
 	latencyScores := make(map[int]float64)
 	jitterScores := make(map[int]float64)
-	packetLossScores := make(map[int]float64)
+	failureRateScores := make(map[int]float64)
 	downloadScores := make(map[int]float64)
 	uploadScores := make(map[int]float64)
 
@@ -146,33 +201,33 @@ func calculateWeightedScore(results []*speedtester.Result, index int) float64 {
 		}
 	}
 
-	// 计算丢包率得分（值越小得分越高）
-	minPacketLoss, maxPacketLoss := float64(0), float64(0)
-	hasValidPacketLoss := false
+	// 计算请求失败率得分（值越小得分越高）
+	minFailureRate, maxFailureRate := float64(0), float64(0)
+	hasValidFailureRate := false
 	for _, r := range results {
-		if !hasValidPacketLoss {
-			minPacketLoss = r.PacketLoss
-			maxPacketLoss = r.PacketLoss
-			hasValidPacketLoss = true
+		if !hasValidFailureRate {
+			minFailureRate = r.RequestFailureRate
+			maxFailureRate = r.RequestFailureRate
+			hasValidFailureRate = true
 		} else {
-			if r.PacketLoss < minPacketLoss {
-				minPacketLoss = r.PacketLoss
+			if r.RequestFailureRate < minFailureRate {
+				minFailureRate = r.RequestFailureRate
 			}
-			if r.PacketLoss > maxPacketLoss {
-				maxPacketLoss = r.PacketLoss
+			if r.RequestFailureRate > maxFailureRate {
+				maxFailureRate = r.RequestFailureRate
 			}
 		}
 	}
 
-	// 计算丢包率得分
-	packetLossRange := maxPacketLoss - minPacketLoss
+	// 计算请求失败率得分
+	failureRateRange := maxFailureRate - minFailureRate
 	for i, r := range results {
-		if hasValidPacketLoss && packetLossRange > 0 {
+		if hasValidFailureRate && failureRateRange > 0 {
 			// 归一化得分：0是最差，1是最好
-			packetLossScores[i] = 1.0 - (r.PacketLoss-minPacketLoss)/packetLossRange
+			failureRateScores[i] = 1.0 - (r.RequestFailureRate-minFailureRate)/failureRateRange
 		} else {
-			// 如果所有节点丢包率相同，则都给满分
-			packetLossScores[i] = 1.0
+			// 如果所有节点请求失败率相同，则都给满分
+			failureRateScores[i] = 1.0
 		}
 	}
 
@@ -239,7 +294,7 @@ func calculateWeightedScore(results []*speedtester.Result, index int) float64 {
 	// 计算加权总分（得分越高越好）
 	totalScore := latencyScores[index]*latencyWeight +
 		jitterScores[index]*jitterWeight +
-		packetLossScores[index]*packetLossWeight +
+		failureRateScores[index]*failureRateWeight +
 		downloadScores[index]*downloadWeight +
 		uploadScores[index]*uploadWeight
 
@@ -248,6 +303,19 @@ func calculateWeightedScore(results []*speedtester.Result, index int) float64 {
 }
 
 func main() {
+	// serve 子命令启动 HTTP API 服务，daemon 子命令按计划运行测试，其余参数作为任务的默认参数
+	if len(os.Args) > 1 && (os.Args[1] == "serve" || os.Args[1] == "daemon") {
+		command := os.Args[1]
+		flag.CommandLine.Parse(os.Args[2:])
+		log.SetLevel(log.SILENT)
+		if command == "serve" {
+			serve()
+		} else {
+			runDaemon()
+		}
+		return
+	}
+
 	flag.Parse()
 	log.SetLevel(log.SILENT)
 
@@ -255,21 +323,9 @@ func main() {
 		log.Fatalln("please specify the configuration file")
 	}
 
-	speedTester := speedtester.New(&speedtester.Config{
-		ConfigPaths:      *configPathsConfig,
-		FilterRegex:      *filterRegexConfig,
-		ServerURL:        *serverURL,
-		DownloadSize:     *downloadSize,
-		UploadSize:       *uploadSize,
-		Timeout:          *timeout,
-		Concurrent:       *concurrent,
-		TestConcurrent:   *testConcurrent,
-		UnlockTest:       *unlockTest,
-		Fast:             *fastMode,
-		MaxLatency:       *maxLatency,
-		MinDownloadSpeed: *minDownloadSpeed,
-		MinUploadSpeed:   *minUploadSpeed,
-	})
+	config := newConfig()
+	budget := config.TrafficBudget
+	speedTester := speedtester.New(config)
 
 	allProxies, err := speedTester.LoadProxies()
 	if err != nil {
@@ -278,11 +334,13 @@ func main() {
 
 	bar := progressbar.Default(int64(len(allProxies)), "测试中...")
 	results := make([]*speedtester.Result, 0)
+	start := time.Now()
 	speedTester.TestProxies(allProxies, func(result *speedtester.Result) {
 		bar.Add(1)
 		bar.Describe(result.ProxyName)
 		results = append(results, result)
 	})
+	duration := time.Since(start)
 
 	// 根据用户指定的字段或默认规则进行排序
 	if *sortFields != "" {
@@ -317,6 +375,11 @@ func main() {
 					if results[i].PacketLoss != results[j].PacketLoss {
 						return results[i].PacketLoss < results[j].PacketLoss
 					}
+				case "failure_rate":
+					// 请求失败率越低越好，所以是小于号
+					if results[i].RequestFailureRate != results[j].RequestFailureRate {
+						return results[i].RequestFailureRate < results[j].RequestFailureRate
+					}
 				case "download":
 					// 下载速度越高越好，所以是大于号
 					if results[i].DownloadSpeed != results[j].DownloadSpeed {
@@ -342,50 +405,25 @@ func main() {
 			// 如果所有指定字段都相等，则按名称排序
 			return results[i].ProxyName < results[j].ProxyName
 		})
-	} else if *fastMode {
-		// 在Fast模式下按延迟排序
-		sort.Slice(results, func(i, j int) bool {
-			// 处理延迟为0（N/A）的情况
-			if results[i].Latency == 0 && results[j].Latency > 0 {
-				// 如果i的延迟为0（N/A），而j有有效延迟，则j应该排在前面
-				return false
-			}
-			if results[i].Latency > 0 && results[j].Latency == 0 {
-				// 如果i有有效延迟，而j的延迟为0（N/A），则i应该排在前面
-				return true
-			}
-			// 如果两者都有有效延迟或都为N/A，则按延迟值排序（延迟越低越好）
-			return results[i].Latency < results[j].Latency
-		})
 	} else {
-		// 默认按下载速度排序
-		sort.Slice(results, func(i, j int) bool {
-			// 如果下载速度不同，按下载速度排序（下载速度越高越好）
-			if results[i].DownloadSpeed != results[j].DownloadSpeed {
-				return results[i].DownloadSpeed > results[j].DownloadSpeed
-			}
-
-			// 如果下载速度相同，处理延迟为0（N/A）的情况
-			if results[i].Latency == 0 && results[j].Latency > 0 {
-				// 如果i的延迟为0（N/A），而j有有效延迟，则j应该排在前面
-				return false
-			}
-			if results[i].Latency > 0 && results[j].Latency == 0 {
-				// 如果i有有效延迟，而j的延迟为0（N/A），则i应该排在前面
-				return true
-			}
-
-			// 如果两者都有有效延迟或都为N/A，则按延迟值排序（延迟越低越好）
-			if results[i].Latency != results[j].Latency {
-				return results[i].Latency < results[j].Latency
-			}
-
-			// 如果延迟也相同，按名称排序
-			return results[i].ProxyName < results[j].ProxyName
-		})
+		// 默认按下载速度排序，Fast模式下按延迟排序
+		subscription.Sort(results, *fastMode)
 	}
 
+	if b := speedTester.Metadata().Baseline; b != nil {
+		fmt.Printf("\n直连基准: %s\n", b)
+	}
 	printResults(results)
+	if *expandDNS {
+		printDNSGroups(results)
+	}
+	if *viaMatrix {
+		printRelayMatrix(results)
+	}
+
+	if budget > 0 {
+		fmt.Printf("总流量: %s / %s\n", speedtester.FormatSize(speedTester.TrafficUsed()), speedtester.FormatSize(budget))
+	}
 
 	if *outputPath != "" {
 		err = saveConfig(results)
@@ -394,6 +432,262 @@ func main() {
 		}
 		fmt.Printf("\nsave config file to: %s\n", *outputPath)
 	}
+
+	if *metricsFile != "" {
+		registry := metrics.NewRegistry(*metricsFile)
+		if err := registry.Observe("cli", start, duration, results, speedTester.TrafficUsed()); err != nil {
+			log.Fatalln("write metrics file failed: %v", err)
+		}
+		fmt.Printf("save metrics file to: %s\n", *metricsFile)
+	}
+}
+
+// newConfig 根据命令行参数创建测试配置
+func newConfig() *speedtester.Config {
+	scaling, err := parseStreamCounts(*scalingStreams)
+	if err != nil {
+		log.Fatalln("parse scaling stream counts failed: %v", err)
+	}
+	budget, err := speedtester.ParseSize(*trafficBudget)
+	if err != nil {
+		log.Fatalln("parse traffic budget failed: %v", err)
+	}
+
+	if *downloadDuration > 0 && *downloadWarmup >= *downloadDuration {
+		log.Fatalln("download-warmup %s must be shorter than download-duration %s", *downloadWarmup, *downloadDuration)
+	}
+	if *soakDuration > 0 && *downloadWarmup >= *soakDuration {
+		log.Fatalln("download-warmup %s must be shorter than soak-duration %s", *downloadWarmup, *soakDuration)
+	}
+
+	if *onlyRelayed {
+		*routeCheck = true
+	}
+
+	if *dnsServers != "" {
+		if err := speedtester.SetupDNS(strings.Split(*dnsServers, ",")); err != nil {
+			log.Fatalln("setup dns failed: %v", err)
+		}
+	}
+
+	reachabilityChecker, err := speedtester.NewReachabilityChecker(*cnCheck, &speedtester.APICheckerConfig{
+		URL:          *cnCheckAPI,
+		Method:       *cnCheckMethod,
+		Field:        *cnCheckField,
+		BlockedValue: *cnCheckBlocked,
+	}, *timeout)
+	if err != nil {
+		log.Fatalln("create reachability checker failed: %v", err)
+	}
+
+	if _, err := speedtester.NewPayloadReader(*payload, 0); err != nil {
+		log.Fatalln("invalid payload: %v", err)
+	}
+
+	backend, err := speedtester.NewSpeedBackend(*speedBackend, *serverURL)
+	if err != nil {
+		log.Fatalln("create speed backend failed: %v", err)
+	}
+	backend = speedtester.WithToken(backend, *serverToken)
+	servers, serverMap, err := loadSpeedServers(*speedBackend, *serverToken, *speedServers, *serverMapPath)
+	if err != nil {
+		log.Fatalln("load speed servers failed: %v", err)
+	}
+	// 直连基准使用第一个测速服务器
+	if len(servers) > 0 {
+		backend = servers[0].Backend
+	}
+
+	return &speedtester.Config{
+		ConfigPaths:      *configPathsConfig,
+		FilterRegex:      *filterRegexConfig,
+		ServerURL:        *serverURL,
+		LatencyURL:       *latencyURL,
+		Insecure:         *insecure,
+		DownloadSize:     *downloadSize,
+		DownloadDuration: *downloadDuration,
+		DownloadWarmup:   *downloadWarmup,
+		UploadSize:       *uploadSize,
+		SoakDuration:     *soakDuration,
+		SoakSize:         *soakSize,
+		Timeout:          *timeout,
+		Concurrent:       *concurrent,
+		ScalingStreams:   scaling,
+		TestConcurrent:   *testConcurrent,
+		Rounds:           *rounds,
+		RoundInterval:    *roundInterval,
+		MaxVariation:     *maxVariation,
+		MaxStreams:       *maxStreams,
+		TrafficBudget:    budget,
+		UnlockTest:       *unlockTest,
+		Fast:             *fastMode,
+		LoadedLatency:    *loadedLatency,
+		UDPTest:          *udpTest,
+		UDPServer:        *udpServer,
+		JitterURL:        *jitterURL,
+		JitterDuration:   *jitterDuration,
+		JitterInterval:   *jitterInterval,
+		EntryProbe:       *entryProbe,
+		ExpandDNS:        *expandDNS,
+		Via:              *via,
+		ViaMatrix:        *viaMatrix,
+		Baseline:         *baseline,
+		RouteCheck:       *routeCheck,
+		Payload:          *payload,
+		ReceiptKey:       *receiptKey,
+		MaxLatency:       *maxLatency,
+		MinDownloadSpeed: *minDownloadSpeed,
+		MinUploadSpeed:   *minUploadSpeed,
+		MaxPacketLoss:    *maxPacketLoss,
+		MaxFailureRate:   *maxFailureRate,
+
+		ReachabilityChecker: reachabilityChecker,
+		SpeedBackend:        backend,
+		SpeedServers:        servers,
+		SpeedServerMap:      serverMap,
+	}
+}
+
+// serve 启动 HTTP API 服务，任务未指定的参数使用命令行参数
+func serve() {
+	var store *history.Store
+	if *historyDB != "" {
+		var err error
+		store, err = history.Open(*historyDB)
+		if err != nil {
+			log.Fatalln("open history database failed: %v", err)
+		}
+		defer store.Close()
+	}
+	server := newAPIServer(context.Background(), newConfig(), store, metrics.NewRegistry(*metricsFile))
+	fmt.Printf("api server listening on %s\n", *listen)
+	log.Fatalln("api server stopped: %v", server.ListenAndServe())
+}
+
+// runDaemon 按 daemon-config 中的计划运行测试并保存历史记录，同时提供 HTTP API 查询历史
+func runDaemon() {
+	config, err := daemon.LoadConfig(*daemonConfig)
+	if err != nil {
+		log.Fatalln("load daemon config failed: %v", err)
+	}
+	if *historyDB == "" {
+		*historyDB = "history.db"
+	}
+	store, err := history.Open(*historyDB)
+	if err != nil {
+		log.Fatalln("open history database failed: %v", err)
+	}
+	defer store.Close()
+
+	defaults := newConfig()
+	registry := metrics.NewRegistry(*metricsFile)
+	d, err := daemon.New(config, *defaults, store, registry)
+	if err != nil {
+		log.Fatalln("create daemon failed: %v", err)
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+	server := newAPIServer(ctx, defaults, store, registry)
+	go func() {
+		fmt.Printf("api server listening on %s\n", *listen)
+		log.Fatalln("api server stopped: %v", server.ListenAndServe())
+	}()
+	d.Run(ctx)
+}
+
+func newAPIServer(ctx context.Context, defaults *speedtester.Config, store *history.Store, registry *metrics.Registry) *apiserver.Server {
+	return apiserver.New(ctx, &apiserver.Config{
+		Listen:         *listen,
+		Token:          *apiToken,
+		ConfigDir:      *apiConfigDir,
+		MaxRunningJobs: *maxJobs,
+		MaxQueuedJobs:  *maxQueuedJobs,
+		Defaults:       *defaults,
+		History:        store,
+
+		SubscriptionToken: *subToken,
+		Subscription:      *subscriptionOptions(),
+		Metrics:           registry,
+	})
+}
+
+// parseStreamCounts 解析逗号分隔的并发连接数列表
+func parseStreamCounts(value string) ([]int, error) {
+	if value == "" {
+		return nil, nil
+	}
+	counts := make([]int, 0)
+	for _, field := range strings.Split(value, ",") {
+		n, err := strconv.Atoi(strings.TrimSpace(field))
+		if err != nil {
+			return nil, err
+		}
+		if n <= 0 {
+			return nil, fmt.Errorf("invalid stream count: %d", n)
+		}
+		counts = append(counts, n)
+	}
+	return counts, nil
+}
+
+// loadSpeedServers 解析逗号分隔的测速服务器列表，以及出口国家到测速服务器的映射文件
+func loadSpeedServers(kind, token, urls, mapPath string) ([]*speedtester.SpeedServer, map[string]*speedtester.SpeedServer, error) {
+	byURL := make(map[string]*speedtester.SpeedServer)
+	newServer := func(serverURL string) (*speedtester.SpeedServer, error) {
+		serverURL = strings.TrimSpace(serverURL)
+		if server, ok := byURL[serverURL]; ok {
+			return server, nil
+		}
+		backend, err := speedtester.NewSpeedBackend(kind, serverURL)
+		if err != nil {
+			return nil, err
+		}
+		name := serverURL
+		if u, err := url.Parse(serverURL); err == nil && u.Host != "" {
+			name = u.Host
+		}
+		server := &speedtester.SpeedServer{Name: name, Backend: speedtester.WithToken(backend, token)}
+		byURL[serverURL] = server
+		return server, nil
+	}
+
+	var servers []*speedtester.SpeedServer
+	if urls != "" {
+		for _, serverURL := range strings.Split(urls, ",") {
+			server, err := newServer(serverURL)
+			if err != nil {
+				return nil, nil, err
+			}
+			servers = append(servers, server)
+		}
+	}
+
+	var serverMap map[string]*speedtester.SpeedServer
+	if mapPath != "" {
+		data, err := os.ReadFile(mapPath)
+		if err != nil {
+			return nil, nil, err
+		}
+		mapping := make(map[string]string)
+		if err := yaml.Unmarshal(data, &mapping); err != nil {
+			return nil, nil, err
+		}
+		serverMap = make(map[string]*speedtester.SpeedServer, len(mapping))
+		for country, serverURL := range mapping {
+			server, err := newServer(serverURL)
+			if err != nil {
+				return nil, nil, err
+			}
+			serverMap[strings.ToUpper(country)] = server
+		}
+	}
+	return servers, serverMap, nil
+}
+
+// showServerUpload 判断测速服务器是否会返回服务端计时的上传回执
+func showServerUpload() bool {
+	return *speedBackend == "download-server" || *receiptKey != ""
 }
 
 func printResults(results []*speedtester.Result) {
@@ -407,12 +701,74 @@ func printResults(results []*speedtester.Result) {
 		"延迟",
 		"抖动",
 		"丢包率",
+		"失败率",
+		"国内",
 		"风险值",
 	}
 
+	// 指定 DNS 时显示实际拨号的服务器 IP
+	if *dnsServers != "" {
+		headers = append(headers, "拨号IP")
+	}
+
+	// 有直连基准时显示保留的速度比例和增加的延迟
+	if *baseline {
+		headers = append(headers, "速度保留/额外延迟")
+	}
+
+	// 配置了多个测速服务器时显示每个节点使用的服务器
+	if *speedServers != "" || *serverMapPath != "" {
+		headers = append(headers, "测速服务器")
+	}
+
+	// 检测线路时显示入口国家和线路类型
+	if *routeCheck {
+		headers = append(headers, "入口国家", "线路")
+	}
+
+	// 入口探测时显示入口延迟和证书信息
+	if *entryProbe {
+		headers = append(headers, "入口延迟", "证书")
+	}
+
+	// 测试UDP时显示UDP延迟
+	if *udpTest {
+		headers = append(headers, "UDP")
+	}
+
+	// 长连接抖动测试时显示到达间隔抖动和丢包/乱序
+	if *jitterDuration > 0 {
+		headers = append(headers, "长连接抖动", "丢包/乱序")
+	}
+
+	// 多轮测试时显示稳定性评分
+	if *rounds > 1 {
+		headers = append(headers, "稳定性")
+	}
+
 	// 如果不是Fast模式，添加速度相关列
 	if !*fastMode {
 		headers = append(headers, "下载速度", "上传速度")
+		// download-server 会返回服务端计时的上传速度
+		if showServerUpload() {
+			headers = append(headers, "服务端上传")
+		}
+		// 按时长测试时额外显示峰值和P10速度
+		if *downloadDuration > 0 {
+			headers = append(headers, "峰值速度", "P10速度")
+		}
+		if *soakDuration > 0 || *soakSize > 0 {
+			headers = append(headers, "限速")
+		}
+		if *loadedLatency {
+			headers = append(headers, "负载延迟")
+		}
+		if *scalingStreams != "" {
+			headers = append(headers, "单线程", "饱和线程数")
+		}
+		if *trafficBudget != "" {
+			headers = append(headers, "流量")
+		}
 	}
 
 	// 检查是否有解锁测试结果，如果有，添加解锁测试结果列
@@ -472,14 +828,37 @@ func printResults(results []*speedtester.Result) {
 			jitterStr = colorRed + jitterStr + colorReset
 		}
 
-		// 丢包率颜色
+		// 丢包率颜色，未进行UDP测试时不着色
 		packetLossStr := result.FormatPacketLoss()
-		if result.PacketLoss < 10 {
-			packetLossStr = colorGreen + packetLossStr + colorReset
-		} else if result.PacketLoss < 20 {
-			packetLossStr = colorYellow + packetLossStr + colorReset
+		if result.UDPSupported {
+			if result.PacketLoss < 10 {
+				packetLossStr = colorGreen + packetLossStr + colorReset
+			} else if result.PacketLoss < 20 {
+				packetLossStr = colorYellow + packetLossStr + colorReset
+			} else {
+				packetLossStr = colorRed + packetLossStr + colorReset
+			}
+		}
+
+		// 请求失败率颜色
+		failureRateStr := result.FormatRequestFailureRate()
+		if result.RequestFailureRate < 10 {
+			failureRateStr = colorGreen + failureRateStr + colorReset
+		} else if result.RequestFailureRate < 20 {
+			failureRateStr = colorYellow + failureRateStr + colorReset
 		} else {
-			packetLossStr = colorRed + packetLossStr + colorReset
+			failureRateStr = colorRed + failureRateStr + colorReset
+		}
+
+		// 国内连通性颜色
+		cnReachableStr := result.FormatCNReachable()
+		switch result.CNCheckVerdict {
+		case speedtester.VerdictReachable:
+			cnReachableStr = colorGreen + cnReachableStr + colorReset
+		case speedtester.VerdictBlocked:
+			cnReachableStr = colorRed + cnReachableStr + colorReset
+		default:
+			cnReachableStr = colorYellow + cnReachableStr + colorReset
 		}
 
 		// 下载速度颜色 (以MB/s为单位判断)
@@ -527,12 +906,125 @@ func printResults(results []*speedtester.Result) {
 			latencyStr,
 			jitterStr,
 			packetLossStr,
+			failureRateStr,
+			cnReachableStr,
 			riskInfoStr,
 		}
 
+		if *dnsServers != "" {
+			dialedIPStr := result.DialedIP
+			if dialedIPStr == "" {
+				dialedIPStr = "N/A"
+			}
+			row = append(row, dialedIPStr)
+		}
+
+		if *baseline {
+			row = append(row, result.FormatOverhead())
+		}
+
+		if *speedServers != "" || *serverMapPath != "" {
+			speedServerStr := result.SpeedServer
+			if speedServerStr == "" {
+				speedServerStr = "N/A"
+			}
+			row = append(row, speedServerStr)
+		}
+
+		if *routeCheck {
+			routeStr := result.FormatRoute()
+			switch result.RouteType {
+			case speedtester.RouteTransit:
+				routeStr = colorGreen + routeStr + colorReset
+			case speedtester.RouteDirect:
+				routeStr = colorYellow + routeStr + colorReset
+			}
+			row = append(row, result.FormatEntryCountry(), routeStr)
+		}
+
+		if *entryProbe {
+			tlsStr := result.FormatEntryTLS()
+			if result.EntryTLSSelfSigned || (!result.EntryTLSExpiry.IsZero() && result.EntryTLSExpiry.Before(time.Now())) {
+				tlsStr = colorRed + tlsStr + colorReset
+			}
+			row = append(row, result.FormatEntryRTT(), tlsStr)
+		}
+
+		if *udpTest {
+			udpStr := result.FormatUDP()
+			if result.UDPSupported {
+				udpStr = colorGreen + udpStr + colorReset
+			} else {
+				udpStr = colorRed + udpStr + colorReset
+			}
+			row = append(row, udpStr)
+		}
+
+		if *jitterDuration > 0 {
+			jitterStr := result.FormatInterarrivalJitter()
+			lossStr := "N/A"
+			if result.JitterRTT > 0 {
+				lossStr = fmt.Sprintf("%.1f%%/%d", result.JitterLoss, result.JitterOutOfOrder)
+			}
+			row = append(row, jitterStr, lossStr)
+		}
+
+		if *rounds > 1 {
+			stabilityStr := result.FormatStability()
+			if result.Unstable {
+				stabilityStr = colorRed + stabilityStr + colorReset
+			} else if result.StabilityScore >= 80 {
+				stabilityStr = colorGreen + stabilityStr + colorReset
+			} else {
+				stabilityStr = colorYellow + stabilityStr + colorReset
+			}
+			row = append(row, stabilityStr)
+		}
+
 		// 如果不是Fast模式，添加速度相关列
 		if !*fastMode {
 			row = append(row, downloadSpeedStr, uploadSpeedStr)
+			if showServerUpload() {
+				serverUploadStr := result.FormatServerUploadSpeed()
+				if result.UploadReceiptVerified {
+					serverUploadStr += " ✓"
+				}
+				row = append(row, serverUploadStr)
+			}
+			if *downloadDuration > 0 {
+				row = append(row, result.FormatDownloadPeakSpeed(), result.FormatDownloadP10Speed())
+			}
+			if *soakDuration > 0 || *soakSize > 0 {
+				throttleStr := result.FormatThrottle()
+				if result.Throttled {
+					throttleStr = colorRed + throttleStr + colorReset
+				} else {
+					throttleStr = colorGreen + throttleStr + colorReset
+				}
+				row = append(row, throttleStr)
+			}
+			if *loadedLatency {
+				loadedLatencyStr := result.FormatLoadedLatency()
+				switch result.BufferbloatGrade {
+				case "A+", "A":
+					loadedLatencyStr = colorGreen + loadedLatencyStr + colorReset
+				case "B", "C":
+					loadedLatencyStr = colorYellow + loadedLatencyStr + colorReset
+				default:
+					loadedLatencyStr = colorRed + loadedLatencyStr + colorReset
+				}
+				row = append(row, loadedLatencyStr)
+			}
+			if *scalingStreams != "" {
+				row = append(row, result.FormatSingleStreamSpeed(), result.FormatSaturation())
+			}
+			if *trafficBudget != "" {
+				trafficStr := result.FormatTrafficUsed()
+				if result.BudgetExhausted {
+					trafficStr = colorYellow + trafficStr + "(预算不足)" + colorReset
+				}
+				row = append(row, trafficStr)
+			}
 		}
 
 		// 如果有解锁测试结果，添加解锁测试结果列
@@ -564,201 +1056,152 @@ func printResults(results []*speedtester.Result) {
 	fmt.Println()
 }
 
-func saveConfig(results []*speedtester.Result) error {
-	filteredResults := make([]*speedtester.Result, 0)
+// printDNSGroups 按服务器域名汇总展开后的节点，显示每个域名下表现最好的 IP
+func printDNSGroups(results []*speedtester.Result) {
+	groups := make(map[string][]*speedtester.Result)
+	parents := make([]string, 0)
 	for _, result := range results {
-		if *maxLatency > 0 && result.Latency > *maxLatency {
+		if result.ParentServer == "" {
 			continue
 		}
-		// 在Fast模式下不根据速度过滤
+		if _, ok := groups[result.ParentServer]; !ok {
+			parents = append(parents, result.ParentServer)
+		}
+		groups[result.ParentServer] = append(groups[result.ParentServer], result)
+	}
+	if len(parents) == 0 {
+		return
+	}
+
+	table := tablewriter.NewWriter(os.Stdout)
+	headers := []string{"服务器域名", "IP数量", "最佳节点", "延迟"}
+	if !*fastMode {
+		headers = append(headers, "下载速度")
+	}
+	table.SetHeader(headers)
+
+	table.SetAutoWrapText(false)
+	table.SetAutoFormatHeaders(true)
+	table.SetHeaderAlignment(tablewriter.ALIGN_LEFT)
+	table.SetAlignment(tablewriter.ALIGN_LEFT)
+	table.SetCenterSeparator("")
+	table.SetColumnSeparator("")
+	table.SetRowSeparator("")
+	table.SetHeaderLine(false)
+	table.SetBorder(false)
+	table.SetTablePadding("\t")
+	table.SetNoWhiteSpace(true)
+
+	// 结果已经按排序规则排好，每组的第一个即为最佳 IP
+	for _, parent := range parents {
+		best := groups[parent][0]
+		row := []string{
+			parent,
+			fmt.Sprintf("%d", len(groups[parent])),
+			best.ProxyName,
+			best.FormatLatency(),
+		}
 		if !*fastMode {
-			if *minDownloadSpeed > 0 && float64(result.DownloadSpeed)/(1024*1024) < *minDownloadSpeed {
-				continue
-			}
-			if *minUploadSpeed > 0 && float64(result.UploadSpeed)/(1024*1024) < *minUploadSpeed {
-				continue
-			}
+			row = append(row, best.FormatDownloadSpeed())
 		}
-		if result.PacketLoss > *maxPacketLoss {
+		table.Append(row)
+	}
+
+	table.Render()
+	fmt.Println()
+}
+
+// printRelayMatrix 以前置节点为行、落地节点为列显示每个组合的延迟和下载速度
+func printRelayMatrix(results []*speedtester.Result) {
+	cells := make(map[string]map[string]*speedtester.Result)
+	relaySet := make(map[string]bool)
+	landingSet := make(map[string]bool)
+	for _, result := range results {
+		if result.Relay == "" {
 			continue
 		}
-		filteredResults = append(filteredResults, result)
+		relaySet[result.Relay] = true
+		landingSet[result.Landing] = true
+		if cells[result.Relay] == nil {
+			cells[result.Relay] = make(map[string]*speedtester.Result)
+		}
+		cells[result.Relay][result.Landing] = result
+	}
+	if len(relaySet) == 0 {
+		return
 	}
 
-	// 应用limit参数限制代理数量
-	if *limit > 0 && len(filteredResults) > *limit {
-		filteredResults = filteredResults[:*limit]
+	relays := make([]string, 0, len(relaySet))
+	for relay := range relaySet {
+		relays = append(relays, relay)
+	}
+	sort.Strings(relays)
+	landings := make([]string, 0, len(landingSet))
+	for landing := range landingSet {
+		landings = append(landings, landing)
 	}
+	sort.Strings(landings)
 
-	// 创建文本内容，每行一个代理链接
-	lines := make([]string, 0, len(filteredResults))
-	countryCount := make(map[string]int)
-	for _, result := range filteredResults {
-		// 构建新的节点名称格式
-		originalName := result.ProxyName
-		newName := ""
-
-		// 根据rename模式处理节点名称
-		switch *renameMode {
-		case "none":
-			// 不重命名，使用原始名称
-			newName = originalName
-		case "add":
-			// 在原始名称后添加新信息
-			newName = originalName
-			// 添加国旗和国家信息
-			if result.IpInfoResult.Country != "" {
-				// 添加国旗
-				if result.IpInfoResult.CountryFlag != "" {
-					newName += " " + result.IpInfoResult.CountryFlag
-				}
-				// 添加中文国家名称
-				if chineseName, ok := utils.CountryCodeMap[result.IpInfoResult.Country]; ok {
-					countryCount[chineseName] += 1
-					newName += chineseName
-					if countryCount[chineseName] >= 1 {
-						newName += fmt.Sprintf("%d", countryCount[chineseName])
-					}
-				}
-				// 添加风险信息
-				if result.IpInfoResult.RiskInfo != "" {
-					newName += " " + result.IpInfoResult.RiskInfo
-				}
-				// 添加地区信息
-				if result.IpInfoResult.Region != "" && result.IpInfoResult.Region != "N/A" {
-					newName += " " + result.IpInfoResult.Region
-				}
-				if result.IpInfoResult.City != "" && result.IpInfoResult.City != "N/A" {
-					newName += " " + result.IpInfoResult.City
-				}
-			}
-			// 添加下载和上传速度信息（非Fast模式下）
-			if !*fastMode {
-				// 格式化下载和上传速度
-				downloadSpeedStr := result.FormatDownloadSpeed()
-				uploadSpeedStr := result.FormatUploadSpeed()
-				// 添加到节点名称中
-				newName += fmt.Sprintf(" ⬇%s ⬆%s", downloadSpeedStr, uploadSpeedStr)
-			}
-			// 添加流媒体解锁信息
-			if result.UnlockResults != nil && len(result.UnlockResults) > 0 {
-				unlockResults := make([]string, 0)
-				for platform, unlockResult := range result.UnlockResults {
-					if unlockResult.Status == "Success" {
-						regionInfo := ""
-						if unlockResult.Region != "" {
-							regionInfo = "(" + unlockResult.Region + ")"
-						}
-						unlockResults = append(unlockResults, platform+regionInfo)
-					}
-				}
-				if len(unlockResults) > 0 {
-					newName += " [" + strings.Join(unlockResults, "| ") + "]"
-				}
-			}
-		case "overwrite":
-			fallthrough
-		default:
-			// 默认为overwrite模式，完全重写节点名称
-			// 添加国旗和国家信息
-			if result.IpInfoResult.Country != "" {
-				// 添加国旗
-				if result.IpInfoResult.CountryFlag != "" {
-					newName += result.IpInfoResult.CountryFlag
-				}
-				// 添加中文国家名称
-				if chineseName, ok := utils.CountryCodeMap[result.IpInfoResult.Country]; ok {
-					countryCount[chineseName] += 1
-					newName += chineseName
-					if countryCount[chineseName] >= 1 {
-						newName += fmt.Sprintf("%d", countryCount[chineseName])
-					}
-				}
-				// 添加风险信息
-				if result.IpInfoResult.RiskInfo != "" {
-					newName += " " + result.IpInfoResult.RiskInfo
-				}
-				// 添加地区信息
-				if result.IpInfoResult.Region != "" && result.IpInfoResult.Region != "N/A" {
-					newName += " " + result.IpInfoResult.Region
-				}
-				if result.IpInfoResult.City != "" && result.IpInfoResult.City != "N/A" {
-					newName += " " + result.IpInfoResult.City
-				}
-			} else {
-				// 如果没有国家信息，使用原始名称
-				newName = originalName
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader(append([]string{"前置 \\ 落地"}, landings...))
+
+	table.SetAutoWrapText(false)
+	table.SetAutoFormatHeaders(false)
+	table.SetHeaderAlignment(tablewriter.ALIGN_LEFT)
+	table.SetAlignment(tablewriter.ALIGN_LEFT)
+	table.SetCenterSeparator("")
+	table.SetColumnSeparator("")
+	table.SetRowSeparator("")
+	table.SetHeaderLine(false)
+	table.SetBorder(false)
+	table.SetTablePadding("\t")
+	table.SetNoWhiteSpace(true)
+
+	for _, relay := range relays {
+		row := []string{relay}
+		for _, landing := range landings {
+			result, ok := cells[relay][landing]
+			if !ok || result.Latency == 0 {
+				row = append(row, colorRed+"N/A"+colorReset)
+				continue
 			}
-			// 添加下载和上传速度信息（非Fast模式下）
+			cell := result.FormatLatency()
 			if !*fastMode {
-				// 格式化下载和上传速度
-				downloadSpeedStr := result.FormatDownloadSpeed()
-				uploadSpeedStr := result.FormatUploadSpeed()
-				// 添加到节点名称中
-				newName += fmt.Sprintf(" ⬇%s ⬆%s", downloadSpeedStr, uploadSpeedStr)
-			}
-			// 添加流媒体解锁信息
-			if result.UnlockResults != nil && len(result.UnlockResults) > 0 {
-				unlockResults := make([]string, 0)
-				for platform, unlockResult := range result.UnlockResults {
-					if unlockResult.Status == "Success" {
-						regionInfo := ""
-						if unlockResult.Region != "" {
-							regionInfo = "(" + unlockResult.Region + ")"
-						}
-						unlockResults = append(unlockResults, platform+regionInfo)
-					}
-				}
-				if len(unlockResults) > 0 {
-					newName += " [" + strings.Join(unlockResults, "| ") + "]"
-				}
+				cell += " / " + result.FormatDownloadSpeed()
 			}
+			row = append(row, cell)
 		}
+		table.Append(row)
+	}
 
-		proxyName := newName
-		// 更新代理配置中的名称
-		// result.ProxyConfig["name"] = proxyName
-
-		link, err := proxylink.GenerateProxyLink(proxyName, result.ProxyType, result.ProxyConfig)
+	table.Render()
+	fmt.Println()
+}
 
-		if err != nil {
-			// 如果生成链接失败，使用代理名称
-			link = proxyName
-		} else {
-			// 对URL进行解码处理
-			decodedLink, err := url.QueryUnescape(link)
-			if err == nil {
-				link = decodedLink
-			}
-		}
-		// 将代理链接添加到文本行中
-		lines = append(lines, link)
-	}
-
-	// // 尝试使用ParseProxiesJSON方法批量生成URLs
-	// // 将filteredResults转换为JSON格式
-	// proxiesJSON := make([]map[string]any, 0, len(filteredResults))
-	// for _, result := range filteredResults {
-	// 	// 确保每个代理配置都有name字段
-	// 	proxyCfg := result.ProxyConfig
-	// 	proxiesJSON = append(proxiesJSON, proxyCfg)
-	// }
-
-	// // 将代理配置数组转换为JSON字节数组
-	// jsonData, err := json.Marshal(proxiesJSON)
-	// if err == nil {
-	// 	// 使用ParseProxiesJSON生成URL格式
-	// 	buffer, err := proxylink.ParseProxiesJSON(jsonData)
-	// 	if err == nil && buffer.Len() > 0 {
-	// 		// 如果成功生成URLs，使用生成的结果替代之前的lines
-	// 		txtData := buffer.String()
-	// 		return os.WriteFile(*outputPath, []byte(txtData), 0o644)
-	// 	}
-	// }
-
-	// 如果批量生成失败，使用之前生成的单个链接
+func saveConfig(results []*speedtester.Result) error {
+	options := subscriptionOptions()
+	filteredResults := subscription.Filter(results, options)
+	names := subscription.Rename(filteredResults, options)
+	// 创建文本内容，每行一个代理链接
+	lines := subscription.Links(filteredResults, names)
 	txtData := strings.Join(lines, "\n")
 
 	// 写入文件
 	return os.WriteFile(*outputPath, []byte(txtData), 0o644)
 }
+
+// subscriptionOptions 根据命令行参数创建订阅的过滤和重命名参数
+func subscriptionOptions() *subscription.Options {
+	return &subscription.Options{
+		MaxLatency:       *maxLatency,
+		MinDownloadSpeed: *minDownloadSpeed,
+		MinUploadSpeed:   *minUploadSpeed,
+		RejectThrottled:  *rejectThrottled,
+		MaxPacketLoss:    *maxPacketLoss,
+		MaxFailureRate:   *maxFailureRate,
+		OnlyRelayed:      *onlyRelayed,
+		Limit:            *limit,
+		RenameMode:       *renameMode,
+		Fast:             *fastMode,
+	}
+}
@@ -0,0 +1,277 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/faceair/clash-speedtest/history"
+	"github.com/faceair/clash-speedtest/speedtester"
+)
+
+const namespace = "clash_speedtest"
+
+// Registry 保存每个配置最近一次运行的节点指标，以及累计的运行次数、耗时、节点数和流量，
+// 按 Prometheus 文本格式输出
+type Registry struct {
+	mu       sync.Mutex
+	profiles map[string]*profileMetrics
+	// 不为空时每次记录运行结果后将指标写入该文件
+	file string
+}
+
+type profileMetrics struct {
+	results []*speedtester.Result
+
+	runs         int64
+	nodes        int64
+	trafficBytes int64
+	duration     time.Duration
+	lastDuration time.Duration
+	lastRun      time.Time
+}
+
+// NewRegistry 创建指标集合，file 不为空时每次运行后写入该文件
+func NewRegistry(file string) *Registry {
+	return &Registry{profiles: make(map[string]*profileMetrics), file: file}
+}
+
+// Observe 记录一次运行的结果，profile 的节点指标替换为本次运行的结果
+func (r *Registry) Observe(profile string, start time.Time, duration time.Duration, results []*speedtester.Result, trafficUsed int64) error {
+	r.observe(profile, start, duration, results, trafficUsed)
+	if r.file == "" {
+		return nil
+	}
+	return r.WriteFile(r.file)
+}
+
+func (r *Registry) observe(profile string, start time.Time, duration time.Duration, results []*speedtester.Result, trafficUsed int64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	p, ok := r.profiles[profile]
+	if !ok {
+		p = &profileMetrics{}
+		r.profiles[profile] = p
+	}
+	p.results = results
+	p.runs++
+	p.nodes += int64(len(results))
+	p.trafficBytes += trafficUsed
+	p.duration += duration
+	p.lastDuration = duration
+	p.lastRun = start
+}
+
+// ServeHTTP 输出 /metrics
+func (r *Registry) ServeHTTP(w http.ResponseWriter, _ *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	r.Write(w)
+}
+
+// WriteFile 将指标写入文件，供 node_exporter 的 textfile collector 读取。
+// 先写入临时文件再重命名，避免被读取到写了一半的文件
+func (r *Registry) WriteFile(path string) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if err := r.Write(tmp); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0o644); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// metric 是同名指标的所有样本
+type metric struct {
+	name    string
+	help    string
+	kind    string
+	samples []sample
+}
+
+type sample struct {
+	labels []string
+	value  float64
+}
+
+func (m *metric) add(value float64, labels ...string) {
+	m.samples = append(m.samples, sample{labels: labels, value: value})
+}
+
+// Write 按 Prometheus 文本格式输出所有指标
+func (r *Registry) Write(w io.Writer) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	newMetric := func(name, kind, help string) *metric {
+		return &metric{name: namespace + "_" + name, kind: kind, help: help}
+	}
+	var (
+		up            = newMetric("proxy_up", "gauge", "Whether the latency test of the proxy succeeded.")
+		latency       = newMetric("proxy_latency_seconds", "gauge", "HTTP latency of the proxy.")
+		jitter        = newMetric("proxy_jitter_seconds", "gauge", "Latency jitter of the proxy.")
+		packetLoss    = newMetric("proxy_packet_loss_ratio", "gauge", "UDP packet loss of the proxy, only exported when the UDP test ran.")
+		download      = newMetric("proxy_download_bytes_per_second", "gauge", "Download speed of the proxy.")
+		upload        = newMetric("proxy_upload_bytes_per_second", "gauge", "Upload speed of the proxy.")
+		unlock        = newMetric("proxy_unlock", "gauge", "Whether the streaming platform is unlocked by the proxy.")
+		risk          = newMetric("proxy_risk_score", "gauge", "Fraud score of the exit IP reported by scamalytics.")
+		traffic       = newMetric("proxy_traffic_bytes", "gauge", "Traffic used by the proxy in the last run.")
+		runs          = newMetric("runs_total", "counter", "Number of completed runs.")
+		runDuration   = newMetric("run_duration_seconds_total", "counter", "Total time spent in runs.")
+		nodesTested   = newMetric("nodes_tested_total", "counter", "Total number of proxies tested.")
+		trafficTotal  = newMetric("traffic_bytes_total", "counter", "Total traffic used by the tests.")
+		lastDuration  = newMetric("last_run_duration_seconds", "gauge", "Duration of the last run.")
+		lastTimestamp = newMetric("last_run_timestamp_seconds", "gauge", "Start time of the last run.")
+		lastNodes     = newMetric("last_run_nodes", "gauge", "Number of proxies tested in the last run.")
+	)
+
+	profiles := make([]string, 0, len(r.profiles))
+	for profile := range r.profiles {
+		profiles = append(profiles, profile)
+	}
+	sort.Strings(profiles)
+
+	for _, profile := range profiles {
+		p := r.profiles[profile]
+		runs.add(float64(p.runs), "profile", profile)
+		runDuration.add(p.duration.Seconds(), "profile", profile)
+		nodesTested.add(float64(p.nodes), "profile", profile)
+		trafficTotal.add(float64(p.trafficBytes), "profile", profile)
+		lastDuration.add(p.lastDuration.Seconds(), "profile", profile)
+		lastTimestamp.add(float64(p.lastRun.Unix()), "profile", profile)
+		lastNodes.add(float64(len(p.results)), "profile", profile)
+
+		// 同名同类型的节点按指纹区分，标签完全相同的节点只输出第一个，避免出现重复的序列
+		seen := make(map[string]bool, len(p.results))
+		for _, result := range p.results {
+			labels := []string{
+				"profile", profile,
+				"name", result.ProxyName,
+				"type", result.ProxyType,
+				"fingerprint", history.Fingerprint(result),
+				"country", result.IpInfoResult.Country,
+				"source", sourceLabel(result.Source),
+			}
+			key := strings.Join(labels, "\x00")
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+			if result.Latency > 0 {
+				up.add(1, labels...)
+			} else {
+				up.add(0, labels...)
+			}
+			// 延迟测试失败的节点不输出延迟和速度，避免 0 值被当作最好的结果
+			if result.Latency > 0 {
+				latency.add(result.Latency.Seconds(), labels...)
+				jitter.add(result.Jitter.Seconds(), labels...)
+				download.add(result.DownloadSpeed, labels...)
+				upload.add(result.UploadSpeed, labels...)
+			}
+			if result.UDPSupported {
+				packetLoss.add(result.PacketLoss/100, labels...)
+			}
+			platforms := make([]string, 0, len(result.UnlockResults))
+			for platform := range result.UnlockResults {
+				platforms = append(platforms, platform)
+			}
+			sort.Strings(platforms)
+			for _, platform := range platforms {
+				unlockResult := result.UnlockResults[platform]
+				value := 0.0
+				if unlockResult.Status == "Success" {
+					value = 1
+				}
+				unlock.add(value, append(labels, "platform", platform, "region", unlockResult.Region)...)
+			}
+			if score, ok := riskScore(result.IpInfoResult.RiskInfo); ok {
+				risk.add(score, labels...)
+			}
+			traffic.add(float64(result.TrafficUsed), labels...)
+		}
+	}
+
+	bw := bufio.NewWriter(w)
+	for _, m := range []*metric{
+		up, latency, jitter, packetLoss, download, upload, unlock, risk, traffic,
+		runs, runDuration, nodesTested, trafficTotal, lastDuration, lastTimestamp, lastNodes,
+	} {
+		if len(m.samples) == 0 {
+			continue
+		}
+		fmt.Fprintf(bw, "# HELP %s %s\n# TYPE %s %s\n", m.name, m.help, m.name, m.kind)
+		for _, s := range m.samples {
+			bw.WriteString(m.name)
+			writeLabels(bw, s.labels)
+			bw.WriteByte(' ')
+			bw.WriteString(formatValue(s.value))
+			bw.WriteByte('\n')
+		}
+	}
+	return bw.Flush()
+}
+
+func writeLabels(w *bufio.Writer, labels []string) {
+	if len(labels) == 0 {
+		return
+	}
+	w.WriteByte('{')
+	for i := 0; i+1 < len(labels); i += 2 {
+		if i > 0 {
+			w.WriteByte(',')
+		}
+		fmt.Fprintf(w, "%s=\"%s\"", labels[i], labelEscaper.Replace(labels[i+1]))
+	}
+	w.WriteByte('}')
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func formatValue(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// sourceLabel 去掉订阅链接中的查询参数和用户信息，订阅链接通常在查询参数中携带 Token
+func sourceLabel(source string) string {
+	u, err := url.Parse(source)
+	if err != nil || u.Host == "" {
+		return source
+	}
+	return u.Scheme + "://" + u.Host + u.Path
+}
+
+// riskScore 从 "[45% 中风险]" 格式的风险信息中解析风险分数
+func riskScore(riskInfo string) (float64, bool) {
+	var score int
+	if _, err := fmt.Sscanf(riskInfo, "[%d%%", &score); err != nil {
+		return 0, false
+	}
+	return float64(score), true
+}
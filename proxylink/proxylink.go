@@ -2,12 +2,13 @@ package proxylink
 
 import (
 	"bytes"
+	"fmt"
 	"strings"
 
 	"github.com/faceair/clash-speedtest/proxylink/parser"
 )
 
-// GenerateProxyLink 主入口函数
+// GenerateProxyLink 主入口函数，不支持的代理类型返回错误
 func GenerateProxyLink(proxyName string, proxyType string, proxyConfig map[string]any) (string, error) {
 	switch strings.ToLower(proxyType) {
 	case "vmess":
@@ -25,7 +26,7 @@ func GenerateProxyLink(proxyName string, proxyType string, proxyConfig map[strin
 	case "tuic", "tuic5":
 		return parser.GenerateTuicLink(proxyName, proxyConfig)
 	default:
-		return proxyName, nil
+		return "", fmt.Errorf("unsupported proxy type: %s", proxyType)
 	}
 }
 
@@ -0,0 +1,326 @@
+package speedserver
+
+import (
+	"crypto/subtle"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/faceair/clash-speedtest/speedtester"
+	"github.com/gobwas/ws"
+	"github.com/gobwas/ws/wsutil"
+	"gopkg.in/yaml.v3"
+)
+
+type Config struct {
+	// 监听地址，UDP 回显服务使用相同的地址
+	Listen string `yaml:"listen"`
+	// TLS 证书和私钥，TLSSelfSigned 为 true 时自动生成自签名证书
+	TLSCert       string `yaml:"tls-cert"`
+	TLSKey        string `yaml:"tls-key"`
+	TLSSelfSigned bool   `yaml:"tls-self-signed"`
+	// 不为空时所有测速接口需要携带 Bearer Token
+	Token string `yaml:"token"`
+	// 单次请求允许下载或上传的最大字节数，0 表示不限制
+	MaxBytes int64 `yaml:"max-bytes"`
+	// /__down 下发的数据，zero|random
+	Payload string `yaml:"payload"`
+	UDPEcho bool   `yaml:"udp-echo"`
+	// 不为空时使用该密钥对上传回执进行 HMAC-SHA256 签名，客户端需配置相同的密钥
+	ReceiptKey string `yaml:"receipt-key"`
+	// /cdn-cgi/trace 返回的 loc 和 colo，分别为服务器所在国家代码和机房代码，为空时返回 XX
+	Loc  string `yaml:"loc"`
+	Colo string `yaml:"colo"`
+}
+
+// LoadConfig 从 YAML 文件读取服务器配置
+func LoadConfig(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	config := &Config{UDPEcho: true}
+	if err := yaml.Unmarshal(data, config); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+type Server struct {
+	config *Config
+	mux    *http.ServeMux
+}
+
+func New(config *Config) (*Server, error) {
+	if config.Listen == "" {
+		config.Listen = ":8080"
+	}
+	if config.Payload == "" {
+		config.Payload = "random"
+	}
+	if _, err := speedtester.NewPayloadReader(config.Payload, 0); err != nil {
+		return nil, err
+	}
+
+	s := &Server{config: config, mux: http.NewServeMux()}
+	s.mux.HandleFunc("/", s.handleIndex)
+	s.mux.HandleFunc("/generate_204", s.auth(s.handleGenerate204))
+	s.mux.HandleFunc("/cdn-cgi/trace", s.auth(s.handleTrace))
+	s.mux.HandleFunc("/__down", s.auth(s.handleDown))
+	s.mux.HandleFunc("/__up", s.auth(s.handleUp))
+	s.mux.HandleFunc("/__ws", s.auth(s.handleWebSocket))
+	return s, nil
+}
+
+func (s *Server) Handler() http.Handler {
+	return s.mux
+}
+
+// ListenAndServe 启动 HTTP(S) 服务，开启 UDP 回显时同时在相同地址监听 UDP
+func (s *Server) ListenAndServe() error {
+	if s.config.UDPEcho {
+		go func() {
+			if err := s.serveUDPEcho(); err != nil {
+				log.Printf("udp echo stopped: %v", err)
+			}
+		}()
+	}
+
+	server := &http.Server{
+		Addr:              s.config.Listen,
+		Handler:           s.mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	switch {
+	case s.config.TLSCert != "" && s.config.TLSKey != "":
+		return server.ListenAndServeTLS(s.config.TLSCert, s.config.TLSKey)
+	case s.config.TLSSelfSigned:
+		cert, err := generateSelfSignedCert()
+		if err != nil {
+			return fmt.Errorf("generate self-signed cert failed: %w", err)
+		}
+		log.Printf("using self-signed cert, sha256 fingerprint: %s", certFingerprint(cert))
+		server.TLSConfig = &tls.Config{Certificates: []tls.Certificate{cert}}
+		return server.ListenAndServeTLS("", "")
+	default:
+		return server.ListenAndServe()
+	}
+}
+
+// auth 校验 Authorization: Bearer <token>，也接受 ?token= 参数以便在延迟探测地址中使用
+func (s *Server) auth(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if s.config.Token != "" {
+			token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if token == "" {
+				token = r.URL.Query().Get("token")
+			}
+			if subtle.ConstantTimeCompare([]byte(token), []byte(s.config.Token)) != 1 {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+		}
+		next(w, r)
+	}
+}
+
+func (s *Server) handleIndex(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html")
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(`<h1>SpeedTest Server</h1>`))
+}
+
+func (s *Server) handleGenerate204(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleTrace 以 Cloudflare /cdn-cgi/trace 的格式返回客户端的出口信息
+func (s *Server) handleTrace(w http.ResponseWriter, r *http.Request) {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		ip = r.RemoteAddr
+	}
+	scheme := "http"
+	tlsVersion := "off"
+	sni := "off"
+	if r.TLS != nil {
+		scheme = "https"
+		tlsVersion = strings.Replace(tls.VersionName(r.TLS.Version), " ", "v", 1)
+		if r.TLS.ServerName != "" {
+			sni = "plaintext"
+		}
+	}
+
+	lines := []string{
+		"h=" + r.Host,
+		"ip=" + ip,
+		fmt.Sprintf("ts=%.3f", float64(time.Now().UnixNano())/1e9),
+		"visit_scheme=" + scheme,
+		"uag=" + r.UserAgent(),
+		"colo=" + traceValue(s.config.Colo),
+		"http=" + strings.ToLower(r.Proto),
+		"loc=" + traceValue(s.config.Loc),
+		"tls=" + tlsVersion,
+		"sni=" + sni,
+	}
+	w.Header().Set("Content-Type", "text/plain")
+	w.Header().Set("Cache-Control", "no-store")
+	w.WriteHeader(http.StatusOK)
+	w.Write([]byte(strings.Join(lines, "\n") + "\n"))
+}
+
+// traceValue 返回大写的配置值，未配置时与 Cloudflare 一样返回 XX
+func traceValue(value string) string {
+	if value == "" {
+		return "XX"
+	}
+	return strings.ToUpper(value)
+}
+
+func (s *Server) handleDown(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	byteSize, err := strconv.Atoi(r.URL.Query().Get("bytes"))
+	if err != nil || byteSize < 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		if err != nil {
+			w.Write([]byte(err.Error()))
+		}
+		return
+	}
+	if s.config.MaxBytes > 0 && int64(byteSize) > s.config.MaxBytes {
+		w.WriteHeader(http.StatusRequestEntityTooLarge)
+		w.Write([]byte(fmt.Sprintf("bytes exceeds the limit of %d", s.config.MaxBytes)))
+		return
+	}
+
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=speedtest-%d.bin", byteSize))
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Header().Set("Content-Length", strconv.Itoa(byteSize))
+	w.WriteHeader(http.StatusOK)
+
+	reader, _ := speedtester.NewPayloadReader(s.config.Payload, byteSize)
+	io.Copy(w, reader)
+}
+
+func (s *Server) handleUp(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	body := io.Reader(r.Body)
+	if s.config.MaxBytes > 0 {
+		if r.ContentLength > s.config.MaxBytes {
+			w.WriteHeader(http.StatusRequestEntityTooLarge)
+			return
+		}
+		body = http.MaxBytesReader(w, r.Body, s.config.MaxBytes)
+	}
+
+	timing := &timingReader{Reader: body}
+	received, err := io.Copy(io.Discard, timing)
+	if err != nil {
+		// 未声明长度或声明长度不实的请求在读取时超过上限
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			w.WriteHeader(http.StatusRequestEntityTooLarge)
+			w.Write([]byte(fmt.Sprintf("body exceeds the limit of %d", maxBytesErr.Limit)))
+			return
+		}
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	// 校验实际收到的字节数与声明的长度一致
+	if r.ContentLength >= 0 && received != r.ContentLength {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte(fmt.Sprintf("received %d bytes, expected %d", received, r.ContentLength)))
+		return
+	}
+
+	// 返回服务端计时的回执，从收到第一个字节开始计时，不受代理缓冲上传数据的影响
+	receipt := &speedtester.UploadReceipt{
+		Bytes:     received,
+		Duration:  timing.last.Sub(timing.first),
+		Timestamp: time.Now().Unix(),
+	}
+	if receipt.Duration > 0 {
+		receipt.Speed = float64(received) / receipt.Duration.Seconds()
+	}
+	if s.config.ReceiptKey != "" {
+		receipt.Sign(s.config.ReceiptKey, r.Header.Get(speedtester.UploadNonceHeader))
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(receipt)
+}
+
+// timingReader 记录读到第一个和最后一个字节的时间
+type timingReader struct {
+	io.Reader
+	first, last time.Time
+}
+
+func (r *timingReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	if n > 0 {
+		now := time.Now()
+		if r.first.IsZero() {
+			r.first = now
+		}
+		r.last = now
+	}
+	return n, err
+}
+
+// handleWebSocket 原样返回收到的 WebSocket 消息，用于在长连接上测试抖动
+func (s *Server) handleWebSocket(w http.ResponseWriter, r *http.Request) {
+	conn, _, _, err := ws.UpgradeHTTP(r, w)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	for {
+		msg, op, err := wsutil.ReadClientData(conn)
+		if err != nil {
+			return
+		}
+		if err := wsutil.WriteServerMessage(conn, op, msg); err != nil {
+			return
+		}
+	}
+}
+
+// serveUDPEcho 原样返回收到的 UDP 数据包，用于测试代理的 UDP 中继
+func (s *Server) serveUDPEcho() error {
+	conn, err := net.ListenPacket("udp", s.config.Listen)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	buf := make([]byte, 2048)
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			return err
+		}
+		conn.WriteTo(buf[:n], addr)
+	}
+}
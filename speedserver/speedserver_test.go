@@ -0,0 +1,85 @@
+package speedserver
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHandleTrace(t *testing.T) {
+	tests := []struct {
+		name     string
+		config   Config
+		wantLoc  string
+		wantColo string
+	}{
+		{name: "unset", wantLoc: "loc=XX", wantColo: "colo=XX"},
+		{name: "static", config: Config{Loc: "jp", Colo: "NRT"}, wantLoc: "loc=JP", wantColo: "colo=NRT"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Server{config: &tt.config}
+			w := httptest.NewRecorder()
+			s.handleTrace(w, httptest.NewRequest(http.MethodGet, "/cdn-cgi/trace", nil))
+			lines := strings.Split(strings.TrimSpace(w.Body.String()), "\n")
+			fields := make(map[string]bool, len(lines))
+			for _, line := range lines {
+				fields[line] = true
+			}
+			if !fields[tt.wantLoc] || !fields[tt.wantColo] {
+				t.Errorf("trace = %q, want %s and %s", lines, tt.wantLoc, tt.wantColo)
+			}
+		})
+	}
+}
+
+func TestHandleUpLimit(t *testing.T) {
+	tests := []struct {
+		name          string
+		body          string
+		contentLength int64
+		want          int
+	}{
+		{name: "within limit", body: "12345678", contentLength: 8, want: http.StatusOK},
+		{name: "declared too large", body: "0123456789", contentLength: 10, want: http.StatusRequestEntityTooLarge},
+		{name: "chunked too large", body: "0123456789", contentLength: -1, want: http.StatusRequestEntityTooLarge},
+		{name: "length mismatch", body: "1234", contentLength: 6, want: http.StatusBadRequest},
+	}
+	s := &Server{config: &Config{MaxBytes: 8}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/__up", io.NopCloser(strings.NewReader(tt.body)))
+			r.ContentLength = tt.contentLength
+			w := httptest.NewRecorder()
+			s.handleUp(w, r)
+			if w.Code != tt.want {
+				t.Errorf("status = %d, want %d: %s", w.Code, tt.want, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestHandleDownLimit(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+		want  int
+	}{
+		{name: "within limit", query: "bytes=8", want: http.StatusOK},
+		{name: "too large", query: "bytes=9", want: http.StatusRequestEntityTooLarge},
+		{name: "invalid", query: "bytes=x", want: http.StatusBadRequest},
+		{name: "negative", query: "bytes=-1", want: http.StatusBadRequest},
+	}
+	s := &Server{config: &Config{MaxBytes: 8}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			s.handleDown(w, httptest.NewRequest(http.MethodGet, "/__down?"+tt.query, nil))
+			if w.Code != tt.want {
+				t.Errorf("status = %d, want %d: %s", w.Code, tt.want, w.Body.String())
+			}
+		})
+	}
+}
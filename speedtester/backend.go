@@ -0,0 +1,222 @@
+package speedtester
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// ErrUploadNotSupported 表示测速后端只能测试下载
+var ErrUploadNotSupported = errors.New("speed backend does not support upload")
+
+// ErrTraceNotSupported 表示测速后端没有 /cdn-cgi/trace 出口信息接口
+var ErrTraceNotSupported = errors.New("speed backend does not support trace")
+
+// SpeedBackend 描述如何向测速服务器发起下载和上传请求
+type SpeedBackend interface {
+	Name() string
+	// NewDownloadRequest 构造下载约 size 字节的请求，响应超出 size 的部分不会被读取
+	NewDownloadRequest(ctx context.Context, size int) (*http.Request, error)
+	// NewUploadRequest 构造上传 size 字节的请求，不支持上传时返回 ErrUploadNotSupported
+	NewUploadRequest(ctx context.Context, body io.Reader, size int) (*http.Request, error)
+	SupportsUpload() bool
+}
+
+// TraceBackend 是提供 Cloudflare 格式 /cdn-cgi/trace 出口信息接口的测速后端，
+// Cloudflare 和 download-server 都实现了该接口
+type TraceBackend interface {
+	NewTraceRequest(ctx context.Context) (*http.Request, error)
+}
+
+// NewSpeedBackend 根据名称创建测速后端，支持 cloudflare|librespeed|static|download-server
+func NewSpeedBackend(kind, serverURL string) (SpeedBackend, error) {
+	serverURL = strings.TrimRight(serverURL, "/")
+	switch kind {
+	case "", "cloudflare":
+		if serverURL == "" {
+			serverURL = "https://speed.cloudflare.com"
+		}
+		return &CloudflareBackend{URL: serverURL}, nil
+	case "download-server":
+		if serverURL == "" {
+			return nil, fmt.Errorf("download-server backend requires server url")
+		}
+		return &DownloadServerBackend{CloudflareBackend{URL: serverURL}}, nil
+	case "librespeed":
+		if serverURL == "" {
+			return nil, fmt.Errorf("librespeed backend requires server url")
+		}
+		return &LibreSpeedBackend{URL: serverURL}, nil
+	case "static":
+		if serverURL == "" {
+			return nil, fmt.Errorf("static backend requires file url")
+		}
+		return &StaticFileBackend{URL: serverURL}, nil
+	default:
+		return nil, fmt.Errorf("unknown speed backend: %s", kind)
+	}
+}
+
+// CloudflareBackend 使用 Cloudflare 测速的 /__down?bytes=N 和 /__up 接口
+type CloudflareBackend struct {
+	URL string
+}
+
+func (b *CloudflareBackend) Name() string {
+	return "cloudflare"
+}
+
+func (b *CloudflareBackend) NewDownloadRequest(ctx context.Context, size int) (*http.Request, error) {
+	return http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("%s/__down?bytes=%d", b.URL, size), nil)
+}
+
+func (b *CloudflareBackend) NewUploadRequest(ctx context.Context, body io.Reader, size int) (*http.Request, error) {
+	return newUploadRequest(ctx, fmt.Sprintf("%s/__up", b.URL), body, size)
+}
+
+func (b *CloudflareBackend) SupportsUpload() bool {
+	return true
+}
+
+func (b *CloudflareBackend) NewTraceRequest(ctx context.Context) (*http.Request, error) {
+	return http.NewRequestWithContext(ctx, http.MethodGet, b.URL+"/cdn-cgi/trace", nil)
+}
+
+// DownloadServerBackend 对应本项目自带的 download-server，接口与 Cloudflare 相同
+type DownloadServerBackend struct {
+	CloudflareBackend
+}
+
+func (b *DownloadServerBackend) Name() string {
+	return "download-server"
+}
+
+// LibreSpeedBackend 使用 LibreSpeed 兼容服务器的 garbage.php 和 empty.php，
+// URL 为这两个文件所在的目录，例如 https://example.com/backend
+type LibreSpeedBackend struct {
+	URL string
+}
+
+func (b *LibreSpeedBackend) Name() string {
+	return "librespeed"
+}
+
+func (b *LibreSpeedBackend) NewDownloadRequest(ctx context.Context, size int) (*http.Request, error) {
+	// garbage.php 以 1MB 为单位返回数据，最多 1024 块
+	chunks := (size + 1024*1024 - 1) / (1024 * 1024)
+	if chunks < 1 {
+		chunks = 1
+	}
+	if chunks > 1024 {
+		chunks = 1024
+	}
+	return http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("%s/garbage.php?ckSize=%d", b.URL, chunks), nil)
+}
+
+func (b *LibreSpeedBackend) NewUploadRequest(ctx context.Context, body io.Reader, size int) (*http.Request, error) {
+	return newUploadRequest(ctx, fmt.Sprintf("%s/empty.php", b.URL), body, size)
+}
+
+func (b *LibreSpeedBackend) SupportsUpload() bool {
+	return true
+}
+
+// StaticFileBackend 下载任意大文件进行测速，只能测试下载
+type StaticFileBackend struct {
+	URL string
+}
+
+func (b *StaticFileBackend) Name() string {
+	return "static"
+}
+
+func (b *StaticFileBackend) NewDownloadRequest(ctx context.Context, size int) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, b.URL, nil)
+	if err != nil {
+		return nil, err
+	}
+	// 支持 Range 的服务器只返回需要的部分，不支持时由调用方截断
+	req.Header.Set("Range", fmt.Sprintf("bytes=0-%d", size-1))
+	return req, nil
+}
+
+func (b *StaticFileBackend) NewUploadRequest(context.Context, io.Reader, int) (*http.Request, error) {
+	return nil, ErrUploadNotSupported
+}
+
+func (b *StaticFileBackend) SupportsUpload() bool {
+	return false
+}
+
+// WithToken 为测速后端的所有请求添加 Authorization: Bearer 头，用于需要认证的自建测速服务器
+func WithToken(backend SpeedBackend, token string) SpeedBackend {
+	if token == "" {
+		return backend
+	}
+	return &tokenBackend{SpeedBackend: backend, token: token}
+}
+
+type tokenBackend struct {
+	SpeedBackend
+	token string
+}
+
+func (b *tokenBackend) NewDownloadRequest(ctx context.Context, size int) (*http.Request, error) {
+	req, err := b.SpeedBackend.NewDownloadRequest(ctx, size)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+b.token)
+	return req, nil
+}
+
+func (b *tokenBackend) NewUploadRequest(ctx context.Context, body io.Reader, size int) (*http.Request, error) {
+	req, err := b.SpeedBackend.NewUploadRequest(ctx, body, size)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+b.token)
+	return req, nil
+}
+
+func (b *tokenBackend) NewTraceRequest(ctx context.Context) (*http.Request, error) {
+	backend, ok := b.SpeedBackend.(TraceBackend)
+	if !ok {
+		return nil, ErrTraceNotSupported
+	}
+	req, err := backend.NewTraceRequest(ctx)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+b.token)
+	return req, nil
+}
+
+func newUploadRequest(ctx context.Context, url string, body io.Reader, size int) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, body)
+	if err != nil {
+		return nil, err
+	}
+	req.ContentLength = int64(size)
+	req.Header.Set("Content-Type", "application/octet-stream")
+	return req, nil
+}
+
+// newDownloadRequest 构造下载请求并要求服务器不压缩响应。
+// 显式指定 Accept-Encoding 后 http.Transport 不会自动解压，统计的是实际传输的字节数
+func (st *SpeedTester) newDownloadRequest(ctx context.Context, size int) (*http.Request, error) {
+	req, err := st.config.SpeedBackend.NewDownloadRequest(ctx, size)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept-Encoding", "identity")
+	return req, nil
+}
+
+// isDownloadStatus 判断下载响应是否成功，Range 请求返回 206
+func isDownloadStatus(code int) bool {
+	return code == http.StatusOK || code == http.StatusPartialContent
+}
@@ -0,0 +1,92 @@
+package speedtester
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/metacubex/mihomo/adapter"
+	"github.com/metacubex/mihomo/adapter/outbound"
+)
+
+// Baseline 是不经过代理直接连接测速服务器的测试结果，作为衡量节点开销的基准
+type Baseline struct {
+	Latency            time.Duration `json:"latency"`
+	Jitter             time.Duration `json:"jitter"`
+	RequestFailureRate float64       `json:"request_failure_rate"`
+	DownloadSpeed      float64       `json:"download_speed"`
+	UploadSpeed        float64       `json:"upload_speed"`
+	// 直连测速使用的测速服务器，配置了多个测速服务器时为第一个
+	SpeedServer string `json:"speed_server,omitempty"`
+}
+
+// RunMetadata 记录一次测试运行的环境信息，便于比较不同地点、不同时间的测试结果
+type RunMetadata struct {
+	StartedAt  time.Time `json:"started_at"`
+	ServerURL  string    `json:"server_url"`
+	Backend    string    `json:"backend"`
+	LatencyURL string    `json:"latency_url"`
+	Baseline   *Baseline `json:"baseline,omitempty"`
+}
+
+// Metadata 返回本次运行的元数据，直连基准在第一次测试节点前生成
+func (st *SpeedTester) Metadata() *RunMetadata {
+	return st.metadata
+}
+
+// runBaseline 使用与节点测试相同的测速地址和探测地址进行一次直连测试
+func (st *SpeedTester) runBaseline(ctx context.Context) {
+	// 包装为 CProxy 以便统计直连测速消耗的流量
+	direct := &CProxy{Proxy: adapter.NewProxy(outbound.NewDirect())}
+
+	latency := st.pingLatency(direct)
+	baseline := &Baseline{
+		Latency:            latency.avgLatency,
+		Jitter:             latency.jitter,
+		RequestFailureRate: latency.failureRate,
+	}
+
+	if len(st.config.SpeedServers) > 0 {
+		server := st.config.SpeedServers[0]
+		baseline.SpeedServer = server.Name
+		st = st.withBackend(server.Backend)
+	}
+
+	// 直连测速同样受流量预算限制
+	planned := st.plannedTraffic()
+	if !st.config.Fast && !st.scheduler.exhausted() {
+		if reservation, ok := st.scheduler.reserve(planned, &direct.traffic); ok {
+			result := &Result{}
+			st.testDownloadStage(ctx, direct, result)
+			st.testUploadStage(ctx, direct, result)
+			st.scheduler.release(reservation)
+			baseline.DownloadSpeed = result.DownloadSpeed
+			baseline.UploadSpeed = result.UploadSpeed
+		}
+	}
+	st.metadata.Baseline = baseline
+}
+
+// applyBaseline 计算节点相对于直连基准保留的下载速度比例和增加的延迟
+func (st *SpeedTester) applyBaseline(result *Result) {
+	baseline := st.metadata.Baseline
+	if baseline == nil {
+		return
+	}
+	// 节点使用了其他测速服务器时速度不可比较
+	if baseline.DownloadSpeed > 0 && result.DownloadSpeed > 0 && result.SpeedServer == baseline.SpeedServer {
+		result.SpeedRetained = result.DownloadSpeed / baseline.DownloadSpeed * 100
+	}
+	if baseline.Latency > 0 && result.Latency > 0 {
+		result.ExtraLatency = result.Latency - baseline.Latency
+	}
+}
+
+func (b *Baseline) String() string {
+	latency := "N/A"
+	if b.Latency > 0 {
+		latency = fmt.Sprintf("%dms", b.Latency.Milliseconds())
+	}
+	return fmt.Sprintf("延迟 %s, 抖动 %dms, 下载 %s, 上传 %s",
+		latency, b.Jitter.Milliseconds(), formatSpeed(b.DownloadSpeed), formatSpeed(b.UploadSpeed))
+}
@@ -0,0 +1,49 @@
+package speedtester
+
+import (
+	"testing"
+	"time"
+)
+
+func TestApplyBaseline(t *testing.T) {
+	baseline := &Baseline{Latency: 20 * time.Millisecond, DownloadSpeed: 1000, SpeedServer: "tokyo"}
+	tests := []struct {
+		name         string
+		baseline     *Baseline
+		result       *Result
+		wantRetained float64
+		wantExtra    time.Duration
+	}{
+		{
+			name:   "no baseline",
+			result: &Result{Latency: 100 * time.Millisecond, DownloadSpeed: 500},
+		},
+		{
+			name:         "same server",
+			baseline:     baseline,
+			result:       &Result{Latency: 100 * time.Millisecond, DownloadSpeed: 500, SpeedServer: "tokyo"},
+			wantRetained: 50,
+			wantExtra:    80 * time.Millisecond,
+		},
+		{
+			name:      "different server",
+			baseline:  baseline,
+			result:    &Result{Latency: 100 * time.Millisecond, DownloadSpeed: 500, SpeedServer: "frankfurt"},
+			wantExtra: 80 * time.Millisecond,
+		},
+		{
+			name:     "failed proxy",
+			baseline: baseline,
+			result:   &Result{SpeedServer: "tokyo"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			st := &SpeedTester{metadata: &RunMetadata{Baseline: tt.baseline}}
+			st.applyBaseline(tt.result)
+			if tt.result.SpeedRetained != tt.wantRetained || tt.result.ExtraLatency != tt.wantExtra {
+				t.Errorf("retained = %v extra = %s, want %v %s", tt.result.SpeedRetained, tt.result.ExtraLatency, tt.wantRetained, tt.wantExtra)
+			}
+		})
+	}
+}
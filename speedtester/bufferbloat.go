@@ -0,0 +1,145 @@
+package speedtester
+
+import (
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/metacubex/mihomo/constant"
+)
+
+const (
+	// 空闲延迟的采样次数
+	idleLatencySamples = 5
+	// 负载期间延迟探测的间隔
+	loadedProbeInterval = 200 * time.Millisecond
+)
+
+// latencyProbe 在下载和上传测试期间持续通过代理测量往返延迟
+type latencyProbe struct {
+	client  *http.Client
+	url     string
+	timeout time.Duration
+	idle    time.Duration
+
+	mu      sync.Mutex
+	samples []time.Duration
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// startLatencyProbe 先在空闲状态下测量延迟，然后在后台持续探测直到 finish 被调用
+func (st *SpeedTester) startLatencyProbe(proxy constant.Proxy) *latencyProbe {
+	p := &latencyProbe{
+		client:  st.createClient(proxy),
+		url:     st.config.LatencyURL,
+		timeout: st.config.Timeout,
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+
+	// 第一次请求用于建立连接，不计入结果，之后的请求复用同一连接，只反映链路往返时间
+	p.ping()
+	idle := make([]time.Duration, 0, idleLatencySamples)
+	for i := 0; i < idleLatencySamples; i++ {
+		if rtt, ok := p.ping(); ok {
+			idle = append(idle, rtt)
+		}
+	}
+	p.idle = medianDuration(idle)
+
+	go p.run()
+	return p
+}
+
+// ping 发送一次探测请求，失败时同样返回请求耗费的时间
+func (p *latencyProbe) ping() (time.Duration, bool) {
+	start := time.Now()
+	resp, err := p.client.Get(p.url)
+	if err != nil {
+		return time.Since(start), false
+	}
+	resp.Body.Close()
+	rtt := time.Since(start)
+	return rtt, resp.StatusCode == http.StatusOK || resp.StatusCode == http.StatusNoContent
+}
+
+// probeOnce 进行一次负载下的探测并记录延迟
+func (p *latencyProbe) probeOnce() {
+	rtt, ok := p.ping()
+	// 负载下请求失败说明队列已严重堆积，按超时时间计入，未设置超时时按请求耗费的时间计入
+	if !ok && p.timeout > rtt {
+		rtt = p.timeout
+	}
+	p.mu.Lock()
+	p.samples = append(p.samples, rtt)
+	p.mu.Unlock()
+}
+
+func (p *latencyProbe) run() {
+	defer close(p.done)
+	ticker := time.NewTicker(loadedProbeInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-p.stop:
+			return
+		case <-ticker.C:
+			p.probeOnce()
+		}
+	}
+}
+
+// finish 停止探测并将空闲/负载延迟写入结果
+func (p *latencyProbe) finish(result *Result) {
+	close(p.stop)
+	<-p.done
+
+	p.mu.Lock()
+	loaded := medianDuration(p.samples)
+	p.mu.Unlock()
+
+	if p.idle == 0 || loaded == 0 {
+		return
+	}
+	result.IdleLatency = p.idle
+	result.LoadedLatency = loaded
+	result.LatencyIncrease = loaded - p.idle
+	if result.LatencyIncrease < 0 {
+		result.LatencyIncrease = 0
+	}
+	result.BufferbloatGrade = bufferbloatGrade(result.LatencyIncrease)
+}
+
+// bufferbloatGrade 根据负载下的延迟增量给出评级
+func bufferbloatGrade(increase time.Duration) string {
+	switch {
+	case increase < 5*time.Millisecond:
+		return "A+"
+	case increase < 30*time.Millisecond:
+		return "A"
+	case increase < 60*time.Millisecond:
+		return "B"
+	case increase < 200*time.Millisecond:
+		return "C"
+	case increase < 400*time.Millisecond:
+		return "D"
+	default:
+		return "F"
+	}
+}
+
+func medianDuration(durations []time.Duration) time.Duration {
+	if len(durations) == 0 {
+		return 0
+	}
+	sorted := make([]time.Duration, len(durations))
+	copy(sorted, durations)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i] < sorted[j]
+	})
+	return sorted[len(sorted)/2]
+}
@@ -0,0 +1,100 @@
+package speedtester
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestBufferbloatGrade(t *testing.T) {
+	tests := []struct {
+		increase time.Duration
+		want     string
+	}{
+		{increase: 0, want: "A+"},
+		{increase: 4 * time.Millisecond, want: "A+"},
+		{increase: 5 * time.Millisecond, want: "A"},
+		{increase: 29 * time.Millisecond, want: "A"},
+		{increase: 30 * time.Millisecond, want: "B"},
+		{increase: 59 * time.Millisecond, want: "B"},
+		{increase: 60 * time.Millisecond, want: "C"},
+		{increase: 199 * time.Millisecond, want: "C"},
+		{increase: 200 * time.Millisecond, want: "D"},
+		{increase: 399 * time.Millisecond, want: "D"},
+		{increase: 400 * time.Millisecond, want: "F"},
+		{increase: 3 * time.Second, want: "F"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.increase.String(), func(t *testing.T) {
+			if got := bufferbloatGrade(tt.increase); got != tt.want {
+				t.Errorf("bufferbloatGrade(%s) = %s, want %s", tt.increase, got, tt.want)
+			}
+		})
+	}
+}
+
+// finishedProbe 返回已经停止探测的 latencyProbe，用于直接测试 finish
+func finishedProbe(idle time.Duration, samples ...time.Duration) *latencyProbe {
+	p := &latencyProbe{
+		idle:    idle,
+		samples: samples,
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	close(p.done)
+	return p
+}
+
+func TestLatencyProbeFinish(t *testing.T) {
+	ms := time.Millisecond
+	tests := []struct {
+		name         string
+		probe        *latencyProbe
+		wantLoaded   time.Duration
+		wantIncrease time.Duration
+		wantGrade    string
+	}{
+		{name: "no idle latency", probe: finishedProbe(0, 50*ms)},
+		{name: "no loaded samples", probe: finishedProbe(50 * ms)},
+		{name: "median of loaded samples", probe: finishedProbe(50*ms, 300*ms, 80*ms, 100*ms), wantLoaded: 100 * ms, wantIncrease: 50 * ms, wantGrade: "B"},
+		{name: "lower than idle", probe: finishedProbe(50*ms, 40*ms), wantLoaded: 40 * ms, wantIncrease: 0, wantGrade: "A+"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := &Result{}
+			tt.probe.finish(result)
+			if result.LoadedLatency != tt.wantLoaded || result.LatencyIncrease != tt.wantIncrease || result.BufferbloatGrade != tt.wantGrade {
+				t.Errorf("loaded = %s, increase = %s, grade = %q, want %s, %s, %q",
+					result.LoadedLatency, result.LatencyIncrease, result.BufferbloatGrade, tt.wantLoaded, tt.wantIncrease, tt.wantGrade)
+			}
+		})
+	}
+}
+
+func TestLatencyProbeFailedPing(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(5 * time.Millisecond)
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		name    string
+		timeout time.Duration
+		min     time.Duration
+	}{
+		// 未设置超时时按请求耗费的时间计入，而不是记为 0
+		{name: "no timeout", timeout: 0, min: 5 * time.Millisecond},
+		{name: "timeout", timeout: time.Second, min: time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := &latencyProbe{client: server.Client(), url: server.URL, timeout: tt.timeout}
+			p.probeOnce()
+			if len(p.samples) != 1 || p.samples[0] < tt.min {
+				t.Errorf("samples = %v, want one sample of at least %s", p.samples, tt.min)
+			}
+		})
+	}
+}
@@ -0,0 +1,137 @@
+package speedtester
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/netip"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/metacubex/mihomo/component/resolver"
+	"github.com/metacubex/mihomo/dns"
+)
+
+// 通过 -dns 指定的解析器，为空时使用系统 DNS
+var serverResolver resolver.Resolver
+
+// SetupDNS 使用指定的 DNS 服务器解析节点的服务器域名，同时作用于 mihomo 拨号和本地的入口探测、连通性检测。
+// 支持 https://（DoH）、tls://（DoT）、quic://、tcp://、udp:// 以及直接填写 IP
+func SetupDNS(servers []string) error {
+	nameservers, err := parseNameServers(servers)
+	if err != nil {
+		return err
+	}
+	if len(nameservers) == 0 {
+		return nil
+	}
+
+	// DoH/DoT 服务器本身为域名时需要先解析，优先使用列表中以 IP 表示的服务器，避免再次依赖被污染的系统 DNS
+	bootstrap := make([]dns.NameServer, 0, len(nameservers))
+	for _, ns := range nameservers {
+		if isIPNameServer(ns) {
+			bootstrap = append(bootstrap, ns)
+		}
+	}
+	if len(bootstrap) == 0 {
+		bootstrap = append(bootstrap, dns.NameServer{Net: "system"})
+	}
+
+	rs := dns.NewResolver(dns.Config{
+		Main:    nameservers,
+		Default: bootstrap,
+		IPv6:    true,
+	})
+	resolver.DefaultResolver = rs
+	resolver.ProxyServerHostResolver = rs.Resolver
+	resolver.DirectHostResolver = rs.Resolver
+	serverResolver = rs.Resolver
+	return nil
+}
+
+// parseNameServers 将 DNS 服务器地址转换为 mihomo 的配置格式
+func parseNameServers(servers []string) ([]dns.NameServer, error) {
+	nameservers := make([]dns.NameServer, 0, len(servers))
+	for _, server := range servers {
+		server = strings.TrimSpace(server)
+		if server == "" {
+			continue
+		}
+		// 直接填写 IP 时使用 UDP
+		if ip, err := netip.ParseAddr(server); err == nil {
+			nameservers = append(nameservers, dns.NameServer{Addr: net.JoinHostPort(ip.String(), "53")})
+			continue
+		}
+		if !strings.Contains(server, "://") {
+			server = "udp://" + server
+		}
+
+		u, err := url.Parse(server)
+		if err != nil {
+			return nil, fmt.Errorf("invalid dns server %s: %w", server, err)
+		}
+		var ns dns.NameServer
+		switch u.Scheme {
+		case "udp":
+			ns = dns.NameServer{Addr: hostWithDefaultPort(u.Host, "53")}
+		case "tcp":
+			ns = dns.NameServer{Net: "tcp", Addr: hostWithDefaultPort(u.Host, "53")}
+		case "tls":
+			ns = dns.NameServer{Net: "tcp-tls", Addr: hostWithDefaultPort(u.Host, "853")}
+		case "quic":
+			ns = dns.NameServer{Net: "quic", Addr: hostWithDefaultPort(u.Host, "853")}
+		case "https":
+			u.Host = hostWithDefaultPort(u.Host, "443")
+			ns = dns.NameServer{Net: "https", Addr: u.String()}
+		default:
+			return nil, fmt.Errorf("unsupported dns server scheme: %s", u.Scheme)
+		}
+		nameservers = append(nameservers, ns)
+	}
+	return nameservers, nil
+}
+
+func hostWithDefaultPort(host, port string) string {
+	if _, _, err := net.SplitHostPort(host); err == nil {
+		return host
+	}
+	return net.JoinHostPort(strings.Trim(host, "[]"), port)
+}
+
+// isIPNameServer 判断 DNS 服务器地址是否为 IP，无需额外解析即可使用
+func isIPNameServer(ns dns.NameServer) bool {
+	addr := ns.Addr
+	if ns.Net == "https" {
+		u, err := url.Parse(addr)
+		if err != nil {
+			return false
+		}
+		addr = u.Host
+	}
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return false
+	}
+	_, err = netip.ParseAddr(host)
+	return err == nil
+}
+
+// lookupIP 解析服务器域名，配置了 -dns 时使用指定的解析器，否则使用系统 DNS
+func lookupIP(host string) ([]net.IP, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if serverResolver == nil {
+		return net.DefaultResolver.LookupIP(ctx, "ip", host)
+	}
+
+	addrs, err := serverResolver.LookupIP(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	ips := make([]net.IP, 0, len(addrs))
+	for _, addr := range addrs {
+		ips = append(ips, net.IP(addr.Unmap().AsSlice()))
+	}
+	return ips, nil
+}
@@ -0,0 +1,126 @@
+package speedtester
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/metacubex/mihomo/constant"
+)
+
+// 入口 TCP 连接的探测次数
+const entryProbeCount = 3
+
+type entryResult struct {
+	rtt        time.Duration
+	tlsSubject string
+	tlsExpiry  time.Time
+	selfSigned bool
+	err        error
+}
+
+// testEntry 不经过代理，从本机直接连接节点服务器，测量入口的 TCP 建连延迟。
+// 节点使用 TLS 时额外完成一次 TLS 握手并记录证书信息
+func (st *SpeedTester) testEntry(proxy *CProxy) *entryResult {
+	result := &entryResult{}
+	// 链式测试时入口为前置节点
+	proxy = proxy.entryProxy()
+	if proxyNetwork(proxy) == "udp" {
+		result.err = fmt.Errorf("entry probe does not apply to udp based proxy")
+		return result
+	}
+
+	server, port := serverAddress(proxy)
+	if server == "" {
+		result.err = fmt.Errorf("proxy has no server address")
+		return result
+	}
+	address := net.JoinHostPort(server, port)
+	dialer := &net.Dialer{Timeout: st.config.Timeout}
+
+	rtts := make([]time.Duration, 0, entryProbeCount)
+	for i := 0; i < entryProbeCount; i++ {
+		start := time.Now()
+		conn, err := dialer.Dial("tcp", address)
+		if err != nil {
+			result.err = err
+			continue
+		}
+		rtts = append(rtts, time.Since(start))
+		conn.Close()
+	}
+	if len(rtts) == 0 {
+		return result
+	}
+	result.rtt = medianDuration(rtts)
+	result.err = nil
+
+	if !usesTLS(proxy) {
+		return result
+	}
+
+	conn, err := dialer.Dial("tcp", address)
+	if err != nil {
+		result.err = err
+		return result
+	}
+	defer conn.Close()
+
+	// 只读取证书信息，不校验证书
+	tlsConn := tls.Client(conn, &tls.Config{
+		ServerName:         tlsServerName(proxy),
+		InsecureSkipVerify: true,
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), st.config.Timeout)
+	defer cancel()
+	if err := tlsConn.HandshakeContext(ctx); err != nil {
+		result.err = err
+		return result
+	}
+
+	certs := tlsConn.ConnectionState().PeerCertificates
+	if len(certs) == 0 {
+		return result
+	}
+	cert := certs[0]
+	result.tlsSubject = cert.Subject.String()
+	result.tlsExpiry = cert.NotAfter
+	result.selfSigned = isSelfSigned(cert)
+	return result
+}
+
+// isSelfSigned 判断证书是否由自身签发。CheckSignatureFrom 要求签发者是 CA，
+// 不能用于 IsCA 为 false 的自签名证书，因此直接用自身的公钥校验签名
+func isSelfSigned(cert *x509.Certificate) bool {
+	return bytes.Equal(cert.RawIssuer, cert.RawSubject) &&
+		cert.CheckSignature(cert.SignatureAlgorithm, cert.RawTBSCertificate, cert.Signature) == nil
+}
+
+// usesTLS 判断节点与服务器之间是否使用标准 TLS。
+// REALITY 节点握手时返回的是伪装站点的证书，不进行 TLS 探测
+func usesTLS(proxy *CProxy) bool {
+	if _, ok := proxy.Config["reality-opts"]; ok {
+		return false
+	}
+	switch proxy.Type() {
+	case constant.Trojan:
+		return true
+	case constant.Vmess, constant.Vless, constant.Http, constant.Socks5:
+		tlsEnabled, _ := proxy.Config["tls"].(bool)
+		return tlsEnabled
+	default:
+		return false
+	}
+}
+
+// tlsServerName 返回 TLS 握手使用的 SNI，未配置时使用服务器域名
+func tlsServerName(proxy *CProxy) string {
+	if sni := getString(proxy.Config, "sni", "servername"); sni != "" {
+		return sni
+	}
+	return getString(proxy.Config, "server")
+}
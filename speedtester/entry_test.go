@@ -0,0 +1,69 @@
+package speedtester
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"testing"
+	"time"
+)
+
+// newTestCert 创建由 parent 签发的证书，parent 为 nil 时为自签名证书
+func newTestCert(t *testing.T, name string, isCA bool, parent *x509.Certificate, parentKey *ecdsa.PrivateKey) (*x509.Certificate, *ecdsa.PrivateKey) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  isCA,
+	}
+	if parent == nil {
+		parent, parentKey = template, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, key
+}
+
+func TestIsSelfSigned(t *testing.T) {
+	// 与 speedserver 生成的证书相同，IsCA 为 false
+	leafSelfSigned, _ := newTestCert(t, "clash-speedtest", false, nil, nil)
+	ca, caKey := newTestCert(t, "test ca", true, nil, nil)
+	issued, _ := newTestCert(t, "issued", false, ca, caKey)
+	// 签发者名称与自身相同但由 CA 签发
+	sameName, _ := newTestCert(t, "test ca", false, ca, caKey)
+
+	tests := []struct {
+		name string
+		cert *x509.Certificate
+		want bool
+	}{
+		{name: "self-signed leaf", cert: leafSelfSigned, want: true},
+		{name: "self-signed ca", cert: ca, want: true},
+		{name: "issued by ca", cert: issued, want: false},
+		{name: "same name issued by ca", cert: sameName, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isSelfSigned(tt.cert); got != tt.want {
+				t.Errorf("isSelfSigned() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
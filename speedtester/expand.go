@@ -0,0 +1,111 @@
+package speedtester
+
+import (
+	"fmt"
+	"net"
+)
+
+// expandedProxy 是由服务器域名的某条解析记录展开出的节点配置
+type expandedProxy struct {
+	config map[string]any
+	// 原始的服务器域名，未展开的节点为空
+	parent string
+}
+
+// expandDNSProxies 将服务器域名解析出的每个 A/AAAA 记录展开为单独的节点。
+// 展开后的节点 server 被替换为对应 IP，名称追加该 IP，同时保留原域名作为 TLS SNI 和 WebSocket Host
+func expandDNSProxies(proxiesConfig []map[string]any) []expandedProxy {
+	expanded := make([]expandedProxy, 0, len(proxiesConfig))
+	for _, config := range proxiesConfig {
+		server := getString(config, "server")
+		if server == "" || net.ParseIP(server) != nil {
+			expanded = append(expanded, expandedProxy{config: config})
+			continue
+		}
+
+		ips, err := lookupServerIPs(server)
+		if err != nil || len(ips) == 0 {
+			expanded = append(expanded, expandedProxy{config: config})
+			continue
+		}
+
+		for _, ip := range ips {
+			expanded = append(expanded, expandedProxy{
+				config: pinServerIP(config, server, ip),
+				parent: server,
+			})
+		}
+	}
+	return expanded
+}
+
+// lookupServerIPs 解析服务器域名的全部 A/AAAA 记录并去重
+func lookupServerIPs(host string) ([]net.IP, error) {
+	ips, err := lookupIP(host)
+	if err != nil {
+		return nil, err
+	}
+
+	seen := make(map[string]bool, len(ips))
+	unique := make([]net.IP, 0, len(ips))
+	for _, ip := range ips {
+		if !seen[ip.String()] {
+			seen[ip.String()] = true
+			unique = append(unique, ip)
+		}
+	}
+	return unique, nil
+}
+
+// sniConfigKey 返回节点配置中指定 TLS SNI 的字段，不使用 TLS 的节点返回空字符串
+func sniConfigKey(config map[string]any) string {
+	tlsEnabled, _ := config["tls"].(bool)
+	switch getString(config, "type") {
+	case "trojan", "hysteria", "hysteria2", "tuic", "anytls":
+		return "sni"
+	case "vmess", "vless":
+		if tlsEnabled {
+			return "servername"
+		}
+	case "http", "socks5":
+		if tlsEnabled {
+			return "sni"
+		}
+	}
+	return ""
+}
+
+// pinServerIP 复制节点配置并将 server 固定为指定 IP
+func pinServerIP(config map[string]any, host string, ip net.IP) map[string]any {
+	pinned := make(map[string]any, len(config)+2)
+	for k, v := range config {
+		pinned[k] = v
+	}
+	pinned["server"] = ip.String()
+	pinned["name"] = fmt.Sprintf("%s [%s]", getString(config, "name"), ip.String())
+
+	// 原本由 server 推导出的 SNI 需要显式指定为原域名
+	if sniKey := sniConfigKey(config); sniKey != "" && getString(config, sniKey) == "" {
+		pinned[sniKey] = host
+	}
+
+	// WebSocket 未指定 Host 时同样默认使用 server，需要保留原域名
+	if wsOpts, ok := config["ws-opts"].(map[string]any); ok {
+		opts := make(map[string]any, len(wsOpts)+1)
+		for k, v := range wsOpts {
+			opts[k] = v
+		}
+		headers := make(map[string]any)
+		if h, ok := wsOpts["headers"].(map[string]any); ok {
+			for k, v := range h {
+				headers[k] = v
+			}
+		}
+		if getString(headers, "Host", "host") == "" {
+			headers["Host"] = host
+		}
+		opts["headers"] = headers
+		pinned["ws-opts"] = opts
+	}
+	return pinned
+}
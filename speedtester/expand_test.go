@@ -0,0 +1,69 @@
+package speedtester
+
+import (
+	"net"
+	"testing"
+)
+
+func TestPinServerIP(t *testing.T) {
+	ip := net.ParseIP("203.0.113.7")
+	tests := []struct {
+		name    string
+		config  map[string]any
+		wantKey string
+		wantSNI string
+	}{
+		{
+			name:   "shadowsocks has no sni",
+			config: map[string]any{"name": "ss", "type": "ss", "server": "example.com"},
+		},
+		{
+			name:    "trojan always uses tls",
+			config:  map[string]any{"name": "trojan", "type": "trojan", "server": "example.com"},
+			wantKey: "sni",
+			wantSNI: "example.com",
+		},
+		{
+			name:    "trojan keeps the configured sni",
+			config:  map[string]any{"name": "trojan", "type": "trojan", "server": "example.com", "sni": "cdn.example.net"},
+			wantKey: "sni",
+			wantSNI: "cdn.example.net",
+		},
+		{
+			name:   "vmess without tls",
+			config: map[string]any{"name": "vmess", "type": "vmess", "server": "example.com"},
+		},
+		{
+			name:    "vmess with tls",
+			config:  map[string]any{"name": "vmess", "type": "vmess", "server": "example.com", "tls": true},
+			wantKey: "servername",
+			wantSNI: "example.com",
+		},
+		{
+			name:    "hysteria2",
+			config:  map[string]any{"name": "hy2", "type": "hysteria2", "server": "example.com"},
+			wantKey: "sni",
+			wantSNI: "example.com",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			pinned := pinServerIP(tt.config, "example.com", ip)
+			if pinned["server"] != ip.String() {
+				t.Errorf("server = %v, want %s", pinned["server"], ip)
+			}
+			for _, key := range []string{"sni", "servername"} {
+				want := ""
+				if key == tt.wantKey {
+					want = tt.wantSNI
+				}
+				if got := getString(pinned, key); got != want {
+					t.Errorf("%s = %q, want %q", key, got, want)
+				}
+			}
+			if _, ok := tt.config["server"].(string); !ok || tt.config["server"] != "example.com" {
+				t.Errorf("original config modified: %v", tt.config)
+			}
+		})
+	}
+}
@@ -0,0 +1,284 @@
+package speedtester
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/gobwas/ws"
+	"github.com/gobwas/ws/wsutil"
+	"github.com/metacubex/mihomo/constant"
+)
+
+const (
+	// 抖动测试默认的发包间隔
+	defaultJitterInterval = 20 * time.Millisecond
+	// 探测包长度：4字节序号 + 8字节发送时间
+	jitterPacketSize = 12
+)
+
+// jitterResult 为长连接定速发包得到的抖动测试结果
+type jitterResult struct {
+	jitter     time.Duration
+	rtt        time.Duration
+	loss       float64
+	outOfOrder int
+	err        error
+}
+
+// jitterConn 是按消息收发的长连接，WebSocket 和 UDP 各有一种实现
+type jitterConn interface {
+	WriteMessage(p []byte) error
+	ReadMessage() ([]byte, error)
+	Close() error
+}
+
+// testJitter 通过代理与 JitterURL 建立一条长连接，在 JitterDuration 内按 JitterInterval 定速发送
+// 带序号和时间戳的探测包，以往返时间作为传输时间按 RFC 3550 计算抖动，并统计丢包和乱序。
+// JitterURL 支持 ws://、wss://（download-server 的 /__ws）和 udp://（download-server 的 UDP 回显）
+func (st *SpeedTester) testJitter(proxy constant.Proxy) *jitterResult {
+	result := &jitterResult{}
+	interval := st.config.JitterInterval
+	if interval <= 0 {
+		interval = defaultJitterInterval
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), st.config.Timeout)
+	conn, err := st.dialJitter(ctx, proxy)
+	cancel()
+	if err != nil {
+		result.err = err
+		return result
+	}
+
+	var mu sync.Mutex
+	var sent uint32
+	received := make(map[uint32]bool)
+	arrivals := make([]jitterArrival, 0, int(st.config.JitterDuration/interval)+1)
+	var sendDone bool
+	start := time.Now()
+
+	// 接收协程只记录回包的序号和时间，统计在测试结束后由 jitterStats 完成
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			packet, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			if len(packet) < jitterPacketSize {
+				continue
+			}
+			arrival := jitterArrival{
+				seq:        binary.BigEndian.Uint32(packet[0:4]),
+				sentAt:     time.Duration(binary.BigEndian.Uint64(packet[4:12])),
+				receivedAt: time.Since(start),
+			}
+
+			mu.Lock()
+			if arrival.seq >= sent || received[arrival.seq] {
+				mu.Unlock()
+				continue
+			}
+			received[arrival.seq] = true
+			arrivals = append(arrivals, arrival)
+			complete := sendDone && len(received) == int(sent)
+			mu.Unlock()
+			if complete {
+				return
+			}
+		}
+	}()
+
+	ticker := time.NewTicker(interval)
+	// 流量预算用完时提前结束
+	for time.Since(start) < st.config.JitterDuration && !st.scheduler.exhausted() {
+		packet := make([]byte, jitterPacketSize)
+		mu.Lock()
+		binary.BigEndian.PutUint32(packet[0:4], sent)
+		binary.BigEndian.PutUint64(packet[4:12], uint64(time.Since(start)))
+		sent++
+		mu.Unlock()
+		if err := conn.WriteMessage(packet); err != nil {
+			result.err = err
+			break
+		}
+		<-ticker.C
+	}
+	ticker.Stop()
+	mu.Lock()
+	sendDone = true
+	complete := len(received) == int(sent)
+	mu.Unlock()
+
+	// 等待最后发出的包返回，超时后关闭连接以结束接收
+	if !complete {
+		select {
+		case <-done:
+		case <-time.After(udpReplyTimeout):
+		}
+	}
+	conn.Close()
+	<-done
+
+	if sent == 0 {
+		return result
+	}
+	stats := jitterStats(sent, arrivals)
+	stats.err = result.err
+	if len(arrivals) == 0 && stats.err == nil {
+		stats.err = fmt.Errorf("no echo received from %s", st.config.JitterURL)
+	}
+	return stats
+}
+
+// jitterArrival 是一个回包的序号、发出时间和收到时间，时间均相对于测试开始
+type jitterArrival struct {
+	seq        uint32
+	sentAt     time.Duration
+	receivedAt time.Duration
+}
+
+// jitterStats 根据发出的包数和按到达顺序排列的回包计算抖动、往返时间、丢包率和乱序数。
+// 探测包由服务器原样回显，无法得到单程时间，因此以往返时间作为 RFC 3550 中的传输时间，
+// 相邻两个回包传输时间之差的绝对值以 1/16 的增益平滑得到抖动。
+// 重复的回包和序号不小于 sent 的回包会被忽略
+func jitterStats(sent uint32, arrivals []jitterArrival) *jitterResult {
+	result := &jitterResult{}
+	if sent == 0 {
+		return result
+	}
+	received := make(map[uint32]bool, len(arrivals))
+	rtts := make([]time.Duration, 0, len(arrivals))
+	var highestSeq uint32
+	var lastTransit, jitter float64
+	for _, arrival := range arrivals {
+		if arrival.seq >= sent || received[arrival.seq] {
+			continue
+		}
+		transit := float64(arrival.receivedAt - arrival.sentAt)
+		if len(received) > 0 {
+			if arrival.seq < highestSeq {
+				result.outOfOrder++
+			}
+			d := transit - lastTransit
+			if d < 0 {
+				d = -d
+			}
+			jitter += (d - jitter) / 16
+		}
+		if arrival.seq > highestSeq {
+			highestSeq = arrival.seq
+		}
+		lastTransit = transit
+		received[arrival.seq] = true
+		rtts = append(rtts, time.Duration(transit))
+	}
+	result.jitter = time.Duration(jitter)
+	result.rtt = medianDuration(rtts)
+	result.loss = float64(int(sent)-len(received)) / float64(sent) * 100
+	return result
+}
+
+// dialJitter 根据 JitterURL 的协议建立 WebSocket 或 UDP 长连接
+func (st *SpeedTester) dialJitter(ctx context.Context, proxy constant.Proxy) (jitterConn, error) {
+	u, err := url.Parse(st.config.JitterURL)
+	if err != nil {
+		return nil, err
+	}
+	switch u.Scheme {
+	case "ws", "wss":
+		dialer := ws.Dialer{
+			NetDial:   st.dialContext(proxy),
+			TLSConfig: &tls.Config{InsecureSkipVerify: st.config.Insecure},
+		}
+		conn, br, _, err := dialer.Dial(ctx, st.config.JitterURL)
+		if err != nil {
+			return nil, err
+		}
+		// 握手时多读取的数据需要先从 br 中读出
+		var reader io.Reader = conn
+		if br != nil {
+			reader = io.MultiReader(br, conn)
+		}
+		return &wsJitterConn{conn: conn, rw: struct {
+			io.Reader
+			io.Writer
+		}{reader, conn}}, nil
+	case "udp":
+		return st.dialUDPJitter(ctx, proxy, u.Host)
+	default:
+		return nil, fmt.Errorf("unsupported jitter url scheme: %s", u.Scheme)
+	}
+}
+
+type wsJitterConn struct {
+	conn net.Conn
+	rw   io.ReadWriter
+}
+
+func (c *wsJitterConn) WriteMessage(p []byte) error {
+	return wsutil.WriteClientBinary(c.conn, p)
+}
+
+func (c *wsJitterConn) ReadMessage() ([]byte, error) {
+	return wsutil.ReadServerBinary(c.rw)
+}
+
+func (c *wsJitterConn) Close() error {
+	return c.conn.Close()
+}
+
+func (st *SpeedTester) dialUDPJitter(ctx context.Context, proxy constant.Proxy, address string) (jitterConn, error) {
+	if !proxy.SupportUDP() {
+		return nil, fmt.Errorf("proxy does not support udp")
+	}
+	addr, err := net.ResolveUDPAddr("udp", address)
+	if err != nil {
+		return nil, err
+	}
+	dstIP, ok := netip.AddrFromSlice(addr.IP)
+	if !ok {
+		return nil, fmt.Errorf("invalid udp address: %s", address)
+	}
+	pc, err := st.listenPacket(ctx, proxy, &constant.Metadata{
+		NetWork: constant.UDP,
+		DstIP:   dstIP.Unmap(),
+		DstPort: uint16(addr.Port),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &udpJitterConn{pc: pc, addr: addr}, nil
+}
+
+type udpJitterConn struct {
+	pc   net.PacketConn
+	addr net.Addr
+}
+
+func (c *udpJitterConn) WriteMessage(p []byte) error {
+	_, err := c.pc.WriteTo(p, c.addr)
+	return err
+}
+
+func (c *udpJitterConn) ReadMessage() ([]byte, error) {
+	buf := make([]byte, 2048)
+	n, _, err := c.pc.ReadFrom(buf)
+	if err != nil {
+		return nil, err
+	}
+	return buf[:n], nil
+}
+
+func (c *udpJitterConn) Close() error {
+	return c.pc.Close()
+}
@@ -0,0 +1,72 @@
+package speedtester
+
+import (
+	"testing"
+	"time"
+)
+
+func TestJitterStats(t *testing.T) {
+	ms := time.Millisecond
+	// arrive 构造一个回包，sent 和 received 的单位为毫秒
+	arrive := func(seq uint32, sent, received int) jitterArrival {
+		return jitterArrival{seq: seq, sentAt: time.Duration(sent) * ms, receivedAt: time.Duration(received) * ms}
+	}
+	tests := []struct {
+		name     string
+		sent     uint32
+		arrivals []jitterArrival
+		want     jitterResult
+	}{
+		{
+			name: "nothing sent",
+			sent: 0,
+			want: jitterResult{},
+		},
+		{
+			name: "all lost",
+			sent: 4,
+			want: jitterResult{loss: 100},
+		},
+		{
+			name:     "constant rtt",
+			sent:     3,
+			arrivals: []jitterArrival{arrive(0, 0, 50), arrive(1, 20, 70), arrive(2, 40, 90)},
+			want:     jitterResult{rtt: 50 * ms},
+		},
+		{
+			// 传输时间 50、66、50：|d| 依次为 16、16，抖动为 1 + (16-1)/16
+			name:     "varying rtt",
+			sent:     3,
+			arrivals: []jitterArrival{arrive(0, 0, 50), arrive(1, 20, 86), arrive(2, 40, 90)},
+			want:     jitterResult{jitter: ms + 15*ms/16, rtt: 50 * ms},
+		},
+		{
+			name:     "loss",
+			sent:     4,
+			arrivals: []jitterArrival{arrive(0, 0, 50), arrive(2, 40, 90), arrive(3, 60, 110)},
+			want:     jitterResult{rtt: 50 * ms, loss: 25},
+		},
+		{
+			// 1 在 2 之后到达记为乱序，乱序包同样参与抖动计算：传输时间 50、50、86，抖动为 36/16
+			name:     "out of order",
+			sent:     3,
+			arrivals: []jitterArrival{arrive(0, 0, 50), arrive(2, 40, 90), arrive(1, 20, 106)},
+			want:     jitterResult{jitter: 36 * ms / 16, rtt: 50 * ms, outOfOrder: 1},
+		},
+		{
+			// 重复的回包和未发出过的序号被忽略
+			name:     "duplicate and unknown",
+			sent:     2,
+			arrivals: []jitterArrival{arrive(0, 0, 50), arrive(0, 0, 80), arrive(5, 100, 150), arrive(1, 20, 70)},
+			want:     jitterResult{rtt: 50 * ms},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := jitterStats(tt.sent, tt.arrivals)
+			if *got != tt.want {
+				t.Errorf("jitterStats() = %+v, want %+v", *got, tt.want)
+			}
+		})
+	}
+}
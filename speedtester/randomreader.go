@@ -0,0 +1,74 @@
+package speedtester
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"fmt"
+	"io"
+)
+
+// RandomReader 使用 AES-CTR 密钥流生成不可压缩的伪随机数据，
+// 避免压缩代理或中间设备把全零数据压缩后虚高测得的速度
+type RandomReader struct {
+	stream       cipher.Stream
+	remainBytes  int64
+	writtenBytes int64
+}
+
+func NewRandomReader(size int) *RandomReader {
+	key := make([]byte, 16)
+	iv := make([]byte, aes.BlockSize)
+	rand.Read(key)
+	rand.Read(iv)
+	block, _ := aes.NewCipher(key)
+	return &RandomReader{
+		stream:      cipher.NewCTR(block, iv),
+		remainBytes: int64(size),
+	}
+}
+
+func (r *RandomReader) Read(p []byte) (n int, err error) {
+	if r.remainBytes <= 0 {
+		return 0, io.EOF
+	}
+
+	toRead := int64(len(p))
+	if toRead > r.remainBytes {
+		toRead = r.remainBytes
+	}
+	buf := p[:toRead]
+	// 对全零数据加密即得到密钥流
+	clear(buf)
+	r.stream.XORKeyStream(buf, buf)
+	r.remainBytes -= toRead
+	r.writtenBytes += toRead
+	return int(toRead), nil
+}
+
+func (r *RandomReader) WrittenBytes() int64 {
+	return r.writtenBytes
+}
+
+func (r *RandomReader) RemainBytes() int64 {
+	return r.remainBytes
+}
+
+// PayloadReader 是上传和下载测试使用的数据源
+type PayloadReader interface {
+	io.Reader
+	WrittenBytes() int64
+	RemainBytes() int64
+}
+
+// NewPayloadReader 根据名称创建数据源，支持 zero|random
+func NewPayloadReader(payload string, size int) (PayloadReader, error) {
+	switch payload {
+	case "", "zero":
+		return NewZeroReader(size), nil
+	case "random":
+		return NewRandomReader(size), nil
+	default:
+		return nil, fmt.Errorf("unknown payload: %s", payload)
+	}
+}
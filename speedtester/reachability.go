@@ -0,0 +1,193 @@
+package speedtester
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net"
+	"net/http"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// ReachabilityVerdict 表示服务器在中国大陆的连通性检测结论
+type ReachabilityVerdict string
+
+const (
+	VerdictReachable ReachabilityVerdict = "reachable"
+	VerdictBlocked   ReachabilityVerdict = "blocked"
+	// 检测本身失败，无法判断是否被墙
+	VerdictUnknown  ReachabilityVerdict = "unknown"
+	VerdictDisabled ReachabilityVerdict = "disabled"
+)
+
+// ReachabilityTarget 是需要检测连通性的服务器地址
+type ReachabilityTarget struct {
+	Server string
+	Port   string
+	// 代理使用的传输层协议，tcp 或 udp
+	Network string
+}
+
+// ReachabilityChecker 检测服务器在中国大陆是否可达。
+// 返回 VerdictUnknown 时 error 说明检测失败的原因，不应被当作被墙处理
+type ReachabilityChecker interface {
+	Check(ctx context.Context, target ReachabilityTarget) (ReachabilityVerdict, error)
+}
+
+// NewReachabilityChecker 根据名称创建连通性检测器，支持 tcp|api|none
+func NewReachabilityChecker(kind string, api *APICheckerConfig, timeout time.Duration) (ReachabilityChecker, error) {
+	switch kind {
+	case "", "tcp":
+		return &TCPReachabilityChecker{Timeout: timeout}, nil
+	case "api":
+		if api == nil {
+			api = &APICheckerConfig{}
+		}
+		return NewAPIReachabilityChecker(api, timeout), nil
+	case "none", "disabled":
+		return DisabledReachabilityChecker{}, nil
+	default:
+		return nil, fmt.Errorf("unknown reachability checker: %s", kind)
+	}
+}
+
+// TCPReachabilityChecker 从本机直接与服务器建立 TCP 连接，不会把节点信息发送给第三方
+type TCPReachabilityChecker struct {
+	Timeout time.Duration
+}
+
+func (c *TCPReachabilityChecker) Check(ctx context.Context, target ReachabilityTarget) (ReachabilityVerdict, error) {
+	// UDP 协议的节点（如 hysteria2、tuic）无法通过 TCP 连接判断
+	if target.Network == "udp" {
+		return VerdictUnknown, fmt.Errorf("tcp probe does not apply to udp based proxy")
+	}
+
+	dialer := &net.Dialer{Timeout: c.Timeout}
+	conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(target.Server, target.Port))
+	if err != nil {
+		// 被取消的检测无法判断，超时则按连接超时处理
+		if errors.Is(ctx.Err(), context.Canceled) {
+			return VerdictUnknown, err
+		}
+		return classifyDialError(err), err
+	}
+	conn.Close()
+	return VerdictReachable, nil
+}
+
+// classifyDialError 只把连接超时和被重置、拒绝视为被墙，
+// 域名解析失败、本机网络不可达等检测本身的失败返回 VerdictUnknown
+func classifyDialError(err error) ReachabilityVerdict {
+	var dnsErr *net.DNSError
+	if errors.As(err, &dnsErr) {
+		return VerdictUnknown
+	}
+	if errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.ECONNRESET) {
+		return VerdictBlocked
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return VerdictBlocked
+	}
+	return VerdictUnknown
+}
+
+// APICheckerConfig 描述第三方检测接口的请求方式和响应映射
+type APICheckerConfig struct {
+	// 接口地址，可以包含 {ip} 和 {port} 占位符
+	URL string
+	// GET 或 POST，POST 时以 multipart 表单提交 ip 和 port
+	Method string
+	// 响应 JSON 中表示检测结果的字段
+	Field string
+	// 字段值包含该字符串时视为被墙
+	BlockedValue string
+}
+
+// APIReachabilityChecker 通过可配置的 HTTP 接口检测连通性
+type APIReachabilityChecker struct {
+	config *APICheckerConfig
+	client *http.Client
+}
+
+func NewAPIReachabilityChecker(config *APICheckerConfig, timeout time.Duration) *APIReachabilityChecker {
+	// 默认使用原先的 ycwxgzs 接口
+	if config.URL == "" {
+		config.URL = "https://api.ycwxgzs.com/ipcheck/index.php"
+	}
+	if config.Method == "" {
+		config.Method = http.MethodPost
+	}
+	if config.Field == "" {
+		config.Field = "tcp"
+	}
+	if config.BlockedValue == "" {
+		config.BlockedValue = "不可用"
+	}
+	return &APIReachabilityChecker{
+		config: config,
+		client: &http.Client{Timeout: timeout},
+	}
+}
+
+func (c *APIReachabilityChecker) Check(ctx context.Context, target ReachabilityTarget) (ReachabilityVerdict, error) {
+	url := strings.NewReplacer("{ip}", target.Server, "{port}", target.Port).Replace(c.config.URL)
+
+	var req *http.Request
+	var err error
+	if strings.EqualFold(c.config.Method, http.MethodPost) {
+		payload := &bytes.Buffer{}
+		writer := multipart.NewWriter(payload)
+		_ = writer.WriteField("ip", target.Server)
+		_ = writer.WriteField("port", target.Port)
+		_ = writer.Close()
+		req, err = http.NewRequestWithContext(ctx, http.MethodPost, url, payload)
+		if err == nil {
+			req.Header.Set("Content-Type", writer.FormDataContentType())
+		}
+	} else {
+		req, err = http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	}
+	if err != nil {
+		return VerdictUnknown, err
+	}
+
+	res, err := c.client.Do(req)
+	if err != nil {
+		return VerdictUnknown, err
+	}
+	defer res.Body.Close()
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		return VerdictUnknown, err
+	}
+	if res.StatusCode != http.StatusOK {
+		return VerdictUnknown, fmt.Errorf("checker api returned status %d", res.StatusCode)
+	}
+
+	var response map[string]any
+	if err := json.Unmarshal(body, &response); err != nil {
+		return VerdictUnknown, err
+	}
+	value, ok := response[c.config.Field]
+	if !ok {
+		return VerdictUnknown, fmt.Errorf("field %s not found in checker api response", c.config.Field)
+	}
+	if strings.Contains(fmt.Sprint(value), c.config.BlockedValue) {
+		return VerdictBlocked, nil
+	}
+	return VerdictReachable, nil
+}
+
+// DisabledReachabilityChecker 不进行连通性检测
+type DisabledReachabilityChecker struct{}
+
+func (DisabledReachabilityChecker) Check(context.Context, ReachabilityTarget) (ReachabilityVerdict, error) {
+	return VerdictDisabled, nil
+}
@@ -0,0 +1,62 @@
+package speedtester
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestClassifyDialError(t *testing.T) {
+	opErr := func(err error) error {
+		return &net.OpError{Op: "dial", Net: "tcp", Err: os.NewSyscallError("connect", err)}
+	}
+	tests := []struct {
+		name string
+		err  error
+		want ReachabilityVerdict
+	}{
+		{name: "refused", err: opErr(syscall.ECONNREFUSED), want: VerdictBlocked},
+		{name: "reset", err: opErr(syscall.ECONNRESET), want: VerdictBlocked},
+		{name: "timeout", err: &net.OpError{Op: "dial", Net: "tcp", Err: os.ErrDeadlineExceeded}, want: VerdictBlocked},
+		{name: "dns failure", err: &net.OpError{Op: "dial", Net: "tcp", Err: &net.DNSError{Err: "no such host", Name: "example.invalid", IsNotFound: true}}, want: VerdictUnknown},
+		{name: "dns timeout", err: &net.OpError{Op: "dial", Net: "tcp", Err: &net.DNSError{Err: "timeout", Name: "example.com", IsTimeout: true}}, want: VerdictUnknown},
+		{name: "network unreachable", err: opErr(syscall.ENETUNREACH), want: VerdictUnknown},
+		{name: "other", err: fmt.Errorf("wrapped: %w", errors.New("boom")), want: VerdictUnknown},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := classifyDialError(tt.err); got != tt.want {
+				t.Errorf("classifyDialError(%v) = %s, want %s", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTCPReachabilityChecker(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	_, port, _ := net.SplitHostPort(listener.Addr().String())
+
+	checker := &TCPReachabilityChecker{Timeout: time.Second}
+	verdict, err := checker.Check(context.Background(), ReachabilityTarget{Server: "127.0.0.1", Port: port, Network: "tcp"})
+	if verdict != VerdictReachable || err != nil {
+		t.Errorf("open port: %s %v", verdict, err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if verdict, _ := checker.Check(ctx, ReachabilityTarget{Server: "127.0.0.1", Port: port, Network: "tcp"}); verdict != VerdictUnknown {
+		t.Errorf("cancelled: %s, want unknown", verdict)
+	}
+	if verdict, _ := checker.Check(context.Background(), ReachabilityTarget{Server: "127.0.0.1", Port: port, Network: "udp"}); verdict != VerdictUnknown {
+		t.Errorf("udp: %s, want unknown", verdict)
+	}
+}
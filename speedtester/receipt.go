@@ -0,0 +1,52 @@
+package speedtester
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"time"
+)
+
+// UploadNonceHeader 是上传请求中携带的随机数，服务器将其写入签名，防止重放旧的回执
+const UploadNonceHeader = "X-Upload-Nonce"
+
+// UploadReceipt 是测速服务器自行计时的上传回执，Duration 为收到第一个字节到最后一个字节的时间
+type UploadReceipt struct {
+	Bytes     int64         `json:"bytes"`
+	Duration  time.Duration `json:"duration"`
+	Speed     float64       `json:"speed"`
+	Timestamp int64         `json:"timestamp"`
+	Signature string        `json:"signature,omitempty"`
+}
+
+// Sign 使用共享密钥对回执和请求的随机数计算 HMAC-SHA256 签名
+func (r *UploadReceipt) Sign(key, nonce string) {
+	r.Signature = r.signature(key, nonce)
+}
+
+// Verify 校验回执签名
+func (r *UploadReceipt) Verify(key, nonce string) bool {
+	expected, err := hex.DecodeString(r.signature(key, nonce))
+	if err != nil {
+		return false
+	}
+	actual, err := hex.DecodeString(r.Signature)
+	if err != nil {
+		return false
+	}
+	return hmac.Equal(expected, actual)
+}
+
+func (r *UploadReceipt) signature(key, nonce string) string {
+	mac := hmac.New(sha256.New, []byte(key))
+	fmt.Fprintf(mac, "%s:%d:%d:%d", nonce, r.Bytes, int64(r.Duration), r.Timestamp)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func newUploadNonce() string {
+	nonce := make([]byte, 16)
+	rand.Read(nonce)
+	return hex.EncodeToString(nonce)
+}
@@ -0,0 +1,38 @@
+package speedtester
+
+import (
+	"testing"
+	"time"
+)
+
+func TestUploadReceiptVerify(t *testing.T) {
+	const key, nonce = "secret", "0123456789abcdef"
+	tests := []struct {
+		name   string
+		tamper func(r *UploadReceipt)
+		key    string
+		nonce  string
+		want   bool
+	}{
+		{name: "valid", key: key, nonce: nonce, want: true},
+		{name: "wrong key", key: "other", nonce: nonce},
+		{name: "replayed nonce", key: key, nonce: "fedcba9876543210"},
+		{name: "bytes changed", tamper: func(r *UploadReceipt) { r.Bytes *= 2 }, key: key, nonce: nonce},
+		{name: "duration changed", tamper: func(r *UploadReceipt) { r.Duration /= 2 }, key: key, nonce: nonce},
+		{name: "timestamp changed", tamper: func(r *UploadReceipt) { r.Timestamp++ }, key: key, nonce: nonce},
+		{name: "unsigned", tamper: func(r *UploadReceipt) { r.Signature = "" }, key: key, nonce: nonce},
+		{name: "malformed signature", tamper: func(r *UploadReceipt) { r.Signature = "not hex" }, key: key, nonce: nonce},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			receipt := &UploadReceipt{Bytes: 10 << 20, Duration: 2 * time.Second, Timestamp: 1700000000}
+			receipt.Sign(key, nonce)
+			if tt.tamper != nil {
+				tt.tamper(receipt)
+			}
+			if got := receipt.Verify(tt.key, tt.nonce); got != tt.want {
+				t.Errorf("Verify() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
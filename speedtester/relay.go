@@ -0,0 +1,113 @@
+package speedtester
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/metacubex/mihomo/adapter"
+	"github.com/metacubex/mihomo/constant"
+	"github.com/metacubex/mihomo/log"
+	"github.com/metacubex/mihomo/tunnel"
+)
+
+// entryProxy 返回从本机直接连接的节点，链式测试时为前置节点
+func (p *CProxy) entryProxy() *CProxy {
+	if p.Relay != nil {
+		return p.Relay
+	}
+	return p
+}
+
+// chainProxies 为每个落地节点设置 dialer-proxy，使其通过前置节点拨号。
+// 矩阵模式下测试每一对前置节点和落地节点的组合
+func (st *SpeedTester) chainProxies(landings, allProxies map[string]*CProxy) (map[string]*CProxy, error) {
+	relays, err := st.loadRelays(allProxies)
+	if err != nil {
+		return nil, err
+	}
+	if len(relays) > 1 && !st.config.ViaMatrix {
+		return nil, fmt.Errorf("found %d relay proxies, use -via-matrix to test every relay and landing pair", len(relays))
+	}
+
+	// dialer-proxy 在拨号时按名称从 tunnel 中查找前置节点
+	registered := make(map[string]constant.Proxy, len(tunnel.Proxies())+len(relays))
+	for name, proxy := range tunnel.Proxies() {
+		registered[name] = proxy
+	}
+	for name, relay := range relays {
+		registered[name] = relay.Proxy
+	}
+	tunnel.UpdateProxies(registered, tunnel.Providers())
+
+	chained := make(map[string]*CProxy)
+	for relayName, relay := range relays {
+		for landingName, landing := range landings {
+			// 前置节点不作为落地节点测试
+			if _, ok := relays[landingName]; ok {
+				continue
+			}
+			// 来自 proxy-provider 的节点没有单独的配置，无法设置 dialer-proxy
+			if getString(landing.Config, "server") == "" {
+				log.Warnln("skip proxy %s: relay only applies to proxies defined in config", landingName)
+				continue
+			}
+
+			name := landingName
+			if st.config.ViaMatrix {
+				name = fmt.Sprintf("%s -> %s", relayName, landingName)
+			}
+			config := make(map[string]any, len(landing.Config)+1)
+			for k, v := range landing.Config {
+				config[k] = v
+			}
+			config["name"] = name
+			config["dialer-proxy"] = relayName
+
+			proxy, err := adapter.ParseProxy(config)
+			if err != nil {
+				return nil, fmt.Errorf("proxy %s: %w", name, err)
+			}
+			chained[name] = &CProxy{
+				Proxy:        proxy,
+				Config:       config,
+				ParentServer: landing.ParentServer,
+				Relay:        relay,
+				RelayName:    relayName,
+				LandingName:  landingName,
+				Source:       landing.Source,
+			}
+		}
+	}
+	return chained, nil
+}
+
+// loadRelays 加载前置节点，-via 可以是配置文件路径、订阅链接，或逗号分隔的节点名称
+func (st *SpeedTester) loadRelays(allProxies map[string]*CProxy) (map[string]*CProxy, error) {
+	via := st.config.Via
+	isFile := false
+	if info, err := os.Stat(via); err == nil && !info.IsDir() {
+		isFile = true
+	}
+	if isFile || strings.HasPrefix(via, "http") {
+		relays, err := st.loadProxies(via)
+		if err != nil {
+			return nil, err
+		}
+		if len(relays) == 0 {
+			return nil, fmt.Errorf("no relay proxy found in %s", via)
+		}
+		return relays, nil
+	}
+
+	relays := make(map[string]*CProxy)
+	for _, name := range strings.Split(via, ",") {
+		name = strings.TrimSpace(name)
+		relay, ok := allProxies[name]
+		if !ok {
+			return nil, fmt.Errorf("relay proxy %s not found", name)
+		}
+		relays[name] = relay
+	}
+	return relays, nil
+}
@@ -0,0 +1,196 @@
+package speedtester
+
+import (
+	"context"
+	"math"
+	"sort"
+	"time"
+)
+
+// MetricStats 多轮测试中某项指标的统计值
+type MetricStats struct {
+	Mean   float64 `json:"mean"`
+	Median float64 `json:"median"`
+	P90    float64 `json:"p90"`
+	Min    float64 `json:"min"`
+	Max    float64 `json:"max"`
+	StdDev float64 `json:"stddev"`
+}
+
+// testProxyRounds 对同一节点进行多轮测试，并将各项指标聚合为中位数，ctx 结束后不再开始新的一轮
+func (st *SpeedTester) testProxyRounds(ctx context.Context, name string, proxy *CProxy) *Result {
+	if st.config.Rounds <= 1 {
+		return st.testProxy(ctx, name, proxy)
+	}
+
+	results := make([]*Result, 0, st.config.Rounds)
+	for i := 0; i < st.config.Rounds; i++ {
+		if i > 0 && st.config.RoundInterval > 0 {
+			select {
+			case <-time.After(st.config.RoundInterval):
+			case <-ctx.Done():
+			}
+		}
+		if i > 0 && ctx.Err() != nil {
+			break
+		}
+		results = append(results, st.testProxy(ctx, name, proxy))
+	}
+	return st.aggregateRounds(results)
+}
+
+// aggregateRounds 以最后一轮延迟测试成功的结果为基础，将延迟、抖动、丢包率和速度替换为多轮的中位数，
+// 这样排序和过滤都基于中位数进行。每项指标只统计实际测得该指标的轮次
+func (st *SpeedTester) aggregateRounds(results []*Result) *Result {
+	result := results[len(results)-1]
+	for i := len(results) - 1; i >= 0; i-- {
+		if results[i].Latency > 0 {
+			result = results[i]
+			break
+		}
+	}
+	result.Rounds = len(results)
+	result.RoundStats = make(map[string]*MetricStats)
+
+	var trafficUsed int64
+	for _, r := range results {
+		trafficUsed += r.TrafficUsed
+	}
+	result.TrafficUsed = trafficUsed
+
+	// 延迟和抖动只统计测试成功的轮次，丢包率只统计 UDP 测试收到回包的轮次
+	latencies := make([]float64, 0, len(results))
+	jitters := make([]float64, 0, len(results))
+	packetLosses := make([]float64, 0, len(results))
+	failureRates := make([]float64, 0, len(results))
+	interarrivalJitters := make([]float64, 0, len(results))
+	for _, r := range results {
+		if r.Latency > 0 {
+			latencies = append(latencies, float64(r.Latency))
+			jitters = append(jitters, float64(r.Jitter))
+		}
+		if r.UDPSupported {
+			packetLosses = append(packetLosses, r.PacketLoss)
+		}
+		failureRates = append(failureRates, r.RequestFailureRate)
+		if r.JitterRTT > 0 {
+			interarrivalJitters = append(interarrivalJitters, float64(r.InterarrivalJitter))
+		}
+	}
+	if stats := calculateMetricStats(latencies); stats != nil {
+		result.RoundStats["latency"] = stats
+		result.Latency = time.Duration(stats.Median)
+	}
+	if stats := calculateMetricStats(jitters); stats != nil {
+		result.RoundStats["jitter"] = stats
+		result.Jitter = time.Duration(stats.Median)
+	}
+	if stats := calculateMetricStats(packetLosses); stats != nil {
+		result.RoundStats["packet_loss"] = stats
+		result.PacketLoss = stats.Median
+	}
+	if stats := calculateMetricStats(failureRates); stats != nil {
+		result.RoundStats["failure_rate"] = stats
+		result.RequestFailureRate = stats.Median
+	}
+	if stats := calculateMetricStats(interarrivalJitters); stats != nil {
+		result.RoundStats["interarrival_jitter"] = stats
+		result.InterarrivalJitter = time.Duration(stats.Median)
+	}
+
+	if !st.config.Fast {
+		downloads := make([]float64, 0, len(results))
+		uploads := make([]float64, 0, len(results))
+		serverUploads := make([]float64, 0, len(results))
+		for _, r := range results {
+			// 延迟测试失败或流量预算不足的轮次没有进行速度测试
+			if r.downloadTested {
+				downloads = append(downloads, r.DownloadSpeed)
+			}
+			if r.uploadTested {
+				uploads = append(uploads, r.UploadSpeed)
+			}
+			// 只有收到上传回执的轮次才有服务端计时的速度
+			if r.ServerUploadSpeed > 0 {
+				serverUploads = append(serverUploads, r.ServerUploadSpeed)
+			}
+		}
+		if stats := calculateMetricStats(downloads); stats != nil {
+			result.RoundStats["download"] = stats
+			result.DownloadSpeed = stats.Median
+		}
+		if stats := calculateMetricStats(uploads); stats != nil {
+			result.RoundStats["upload"] = stats
+			result.UploadSpeed = stats.Median
+		}
+		if stats := calculateMetricStats(serverUploads); stats != nil {
+			result.RoundStats["server_upload"] = stats
+			result.ServerUploadSpeed = stats.Median
+		}
+	}
+
+	// 以延迟和下载速度的变异系数衡量稳定性，各轮速度均为0时视为不稳定
+	variations := make([]float64, 0, 2)
+	for _, metric := range []string{"latency", "download"} {
+		if stats, ok := result.RoundStats[metric]; ok {
+			variations = append(variations, stats.coefficientOfVariation())
+		}
+	}
+	var maxVariation, totalVariation float64
+	for _, v := range variations {
+		totalVariation += v
+		if v > maxVariation {
+			maxVariation = v
+		}
+	}
+	if len(variations) > 0 {
+		result.StabilityScore = math.Max(0, 100*(1-totalVariation/float64(len(variations))))
+	}
+	result.Unstable = math.IsInf(maxVariation, 1) || (st.config.MaxVariation > 0 && maxVariation > st.config.MaxVariation)
+
+	return result
+}
+
+// coefficientOfVariation 返回标准差与平均值之比，平均值为0时无法衡量，返回 +Inf
+func (m *MetricStats) coefficientOfVariation() float64 {
+	if m.Mean == 0 {
+		return math.Inf(1)
+	}
+	return m.StdDev / m.Mean
+}
+
+func calculateMetricStats(values []float64) *MetricStats {
+	if len(values) == 0 {
+		return nil
+	}
+	sorted := make([]float64, len(values))
+	copy(sorted, values)
+	sort.Float64s(sorted)
+
+	var sum float64
+	for _, v := range sorted {
+		sum += v
+	}
+	mean := sum / float64(len(sorted))
+	var variance float64
+	for _, v := range sorted {
+		variance += (v - mean) * (v - mean)
+	}
+	variance /= float64(len(sorted))
+
+	stats := &MetricStats{
+		Mean:   mean,
+		P90:    percentile(sorted, 90),
+		Min:    sorted[0],
+		Max:    sorted[len(sorted)-1],
+		StdDev: math.Sqrt(variance),
+	}
+	// 偶数个样本时取中间两个值的平均
+	mid := len(sorted) / 2
+	if len(sorted)%2 == 0 {
+		stats.Median = (sorted[mid-1] + sorted[mid]) / 2
+	} else {
+		stats.Median = sorted[mid]
+	}
+	return stats
+}
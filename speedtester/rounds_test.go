@@ -0,0 +1,127 @@
+package speedtester
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCalculateMetricStats(t *testing.T) {
+	tests := []struct {
+		name   string
+		values []float64
+		want   *MetricStats
+	}{
+		{name: "empty", values: nil, want: nil},
+		{name: "single", values: []float64{5}, want: &MetricStats{Mean: 5, Median: 5, P90: 5, Min: 5, Max: 5}},
+		{name: "odd", values: []float64{3, 1, 2}, want: &MetricStats{Mean: 2, Median: 2, P90: 3, Min: 1, Max: 3, StdDev: 0.816496580927726}},
+		{name: "even", values: []float64{4, 2, 8, 6}, want: &MetricStats{Mean: 5, Median: 5, P90: 8, Min: 2, Max: 8, StdDev: 2.23606797749979}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := calculateMetricStats(tt.values)
+			if (got == nil) != (tt.want == nil) {
+				t.Fatalf("calculateMetricStats() = %v, want %v", got, tt.want)
+			}
+			if got != nil && *got != *tt.want {
+				t.Errorf("calculateMetricStats() = %+v, want %+v", *got, *tt.want)
+			}
+		})
+	}
+}
+
+// round 构造一轮测试结果，latency 为0表示延迟测试失败，download 小于0表示没有进行速度测试
+func round(latency time.Duration, download float64, country string) *Result {
+	r := &Result{Latency: latency, IpInfoResult: IpInfo{Country: country}, TrafficUsed: 10}
+	if download >= 0 {
+		r.downloadTested = true
+		r.uploadTested = true
+		r.DownloadSpeed = download
+		r.UploadSpeed = download / 2
+	}
+	return r
+}
+
+func TestAggregateRounds(t *testing.T) {
+	tests := []struct {
+		name         string
+		rounds       []*Result
+		maxVariation float64
+		wantLatency  time.Duration
+		wantDownload float64
+		wantUpload   float64
+		wantCountry  string
+		wantScore    float64
+		wantUnstable bool
+	}{
+		{
+			name:         "stable",
+			rounds:       []*Result{round(100*time.Millisecond, 1000, "JP"), round(100*time.Millisecond, 1000, "JP")},
+			wantLatency:  100 * time.Millisecond,
+			wantDownload: 1000,
+			wantUpload:   500,
+			wantCountry:  "JP",
+			wantScore:    100,
+		},
+		{
+			name:         "failed last round is not the base",
+			rounds:       []*Result{round(100*time.Millisecond, 1000, "JP"), round(120*time.Millisecond, 1000, "JP"), round(0, -1, "")},
+			wantLatency:  110 * time.Millisecond,
+			wantDownload: 1000,
+			wantUpload:   500,
+			wantCountry:  "JP",
+			wantScore:    100 * (1 - (10.0/110)/2),
+		},
+		{
+			name:         "rounds without speed test are skipped",
+			rounds:       []*Result{round(100*time.Millisecond, -1, "JP"), round(100*time.Millisecond, 800, "JP"), round(100*time.Millisecond, 1200, "JP")},
+			wantLatency:  100 * time.Millisecond,
+			wantDownload: 1000,
+			wantUpload:   500,
+			wantCountry:  "JP",
+			wantScore:    90,
+		},
+		{
+			name:         "zero speed in every round is unstable",
+			rounds:       []*Result{round(100*time.Millisecond, 0, "JP"), round(100*time.Millisecond, 0, "JP")},
+			wantLatency:  100 * time.Millisecond,
+			wantCountry:  "JP",
+			wantScore:    0,
+			wantUnstable: true,
+		},
+		{
+			name:         "variation above the threshold",
+			rounds:       []*Result{round(100*time.Millisecond, 500, "JP"), round(100*time.Millisecond, 1500, "JP")},
+			maxVariation: 0.3,
+			wantLatency:  100 * time.Millisecond,
+			wantDownload: 1000,
+			wantUpload:   500,
+			wantCountry:  "JP",
+			wantScore:    75,
+			wantUnstable: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			st := &SpeedTester{config: &Config{MaxVariation: tt.maxVariation}}
+			result := st.aggregateRounds(tt.rounds)
+			if result.Rounds != len(tt.rounds) || result.TrafficUsed != int64(10*len(tt.rounds)) {
+				t.Errorf("rounds = %d traffic = %d", result.Rounds, result.TrafficUsed)
+			}
+			if result.Latency != tt.wantLatency {
+				t.Errorf("latency = %s, want %s", result.Latency, tt.wantLatency)
+			}
+			if result.DownloadSpeed != tt.wantDownload || result.UploadSpeed != tt.wantUpload {
+				t.Errorf("speed = %v/%v, want %v/%v", result.DownloadSpeed, result.UploadSpeed, tt.wantDownload, tt.wantUpload)
+			}
+			if result.IpInfoResult.Country != tt.wantCountry {
+				t.Errorf("country = %q, want %q", result.IpInfoResult.Country, tt.wantCountry)
+			}
+			if diff := result.StabilityScore - tt.wantScore; diff > 1e-9 || diff < -1e-9 {
+				t.Errorf("stability = %v, want %v", result.StabilityScore, tt.wantScore)
+			}
+			if result.Unstable != tt.wantUnstable {
+				t.Errorf("unstable = %v, want %v", result.Unstable, tt.wantUnstable)
+			}
+		})
+	}
+}
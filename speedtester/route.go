@@ -0,0 +1,102 @@
+package speedtester
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/faceair/clash-speedtest/utils"
+)
+
+// RouteType 表示节点入口与出口之间的线路类型
+type RouteType string
+
+const (
+	// 入口 IP 与出口 IP 相同，没有经过中转
+	RouteDirect RouteType = "direct"
+	// 入口与出口 IP 不同但位于同一 ASN
+	RouteRelayed RouteType = "relayed"
+	// 入口与出口位于同一国家的不同 ASN，无法确认是否为同一服务商的中转
+	RouteCrossASN RouteType = "cross_asn"
+	// 入口与出口位于不同国家，例如国内入口、日本出口的 IEPL 中转
+	RouteTransit RouteType = "transit"
+)
+
+type routeResult struct {
+	entryIP      string
+	entryCountry string
+	entryASN     string
+	exitASN      string
+	routeType    RouteType
+	err          error
+}
+
+// testRoute 查询节点入口地址和出口 IP 的归属信息并判断线路类型。
+// 链式测试时入口为前置节点的地址
+func (st *SpeedTester) testRoute(proxy *CProxy, exitIP string) *routeResult {
+	result := &routeResult{}
+	entryIP, _ := serverAddress(proxy.entryProxy())
+	if entryIP == "" {
+		result.err = fmt.Errorf("proxy has no server address")
+		return result
+	}
+	result.entryIP = entryIP
+
+	var entryGeo, exitGeo *utils.IPGeo
+	var entryErr, exitErr error
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		entryGeo, entryErr = utils.LookupIPGeo(entryIP)
+	}()
+	if exitIP != "" && exitIP != entryIP {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			exitGeo, exitErr = utils.LookupIPGeo(exitIP)
+		}()
+	}
+	wg.Wait()
+
+	if entryErr != nil {
+		result.err = entryErr
+		return result
+	}
+	result.entryCountry = entryGeo.Country
+	result.entryASN = entryGeo.ASN
+
+	switch {
+	case exitIP == "":
+		result.err = fmt.Errorf("exit ip is unknown")
+	case exitIP == entryIP:
+		result.exitASN = entryGeo.ASN
+		result.routeType = RouteDirect
+	case exitErr != nil:
+		result.err = exitErr
+	default:
+		result.exitASN = exitGeo.ASN
+		result.routeType = classifyRoute(entryGeo, exitGeo)
+		if result.routeType == "" {
+			result.err = fmt.Errorf("asn or country of the entry or exit is unknown")
+		}
+	}
+	return result
+}
+
+// classifyRoute 根据入口和出口的 ASN 与国家判断入口与出口 IP 不同的节点属于哪种中转，
+// 归属信息不完整无法判断时返回空
+func classifyRoute(entry, exit *utils.IPGeo) RouteType {
+	if entry.ASN != "" && entry.ASN == exit.ASN {
+		return RouteRelayed
+	}
+	if entry.Country == "" || exit.Country == "" {
+		return ""
+	}
+	if entry.Country != exit.Country {
+		return RouteTransit
+	}
+	if entry.ASN == "" || exit.ASN == "" {
+		return ""
+	}
+	return RouteCrossASN
+}
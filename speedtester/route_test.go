@@ -0,0 +1,34 @@
+package speedtester
+
+import (
+	"testing"
+
+	"github.com/faceair/clash-speedtest/utils"
+)
+
+func TestClassifyRoute(t *testing.T) {
+	geo := func(country, asn string) *utils.IPGeo {
+		return &utils.IPGeo{Country: country, ASN: asn}
+	}
+	tests := []struct {
+		name  string
+		entry *utils.IPGeo
+		exit  *utils.IPGeo
+		want  RouteType
+	}{
+		{name: "same asn", entry: geo("HK", "AS4760"), exit: geo("HK", "AS4760"), want: RouteRelayed},
+		{name: "same asn across countries", entry: geo("HK", "AS13335"), exit: geo("JP", "AS13335"), want: RouteRelayed},
+		{name: "different country", entry: geo("CN", "AS4809"), exit: geo("JP", "AS2516"), want: RouteTransit},
+		{name: "different country without asn", entry: geo("CN", ""), exit: geo("JP", ""), want: RouteTransit},
+		{name: "different asn in same country", entry: geo("JP", "AS2516"), exit: geo("JP", "AS9370"), want: RouteCrossASN},
+		{name: "same country missing asn", entry: geo("JP", ""), exit: geo("JP", "AS9370"), want: ""},
+		{name: "missing country", entry: geo("", "AS2516"), exit: geo("JP", "AS9370"), want: ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := classifyRoute(tt.entry, tt.exit); got != tt.want {
+				t.Errorf("classifyRoute() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,89 @@
+package speedtester
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/metacubex/mihomo/constant"
+)
+
+// 达到最高速度的该比例即视为已饱和
+const saturationRatio = 0.9
+
+// ScalingPoint 表示某个并发连接数下的下载速度
+type ScalingPoint struct {
+	Streams int     `json:"streams"`
+	Speed   float64 `json:"speed"`
+}
+
+// testScaling 依次使用不同的并发连接数进行下载测试，返回各连接数下的速度
+func (st *SpeedTester) testScaling(ctx context.Context, proxy constant.Proxy) []ScalingPoint {
+	points := make([]ScalingPoint, 0, len(st.config.ScalingStreams))
+	for _, streams := range st.config.ScalingStreams {
+		if streams <= 0 {
+			continue
+		}
+		// 取消后不再测试剩余的连接数
+		if ctx.Err() != nil {
+			break
+		}
+		points = append(points, ScalingPoint{
+			Streams: streams,
+			Speed:   st.testDownloadStreams(ctx, proxy, streams),
+		})
+	}
+	return points
+}
+
+// testDownloadStreams 使用 streams 个并发连接进行下载测试，按墙钟时间计算合计速度
+func (st *SpeedTester) testDownloadStreams(ctx context.Context, proxy constant.Proxy, streams int) float64 {
+	if st.config.DownloadDuration > 0 {
+		return st.testDownloadDuration(ctx, proxy, streams, st.config.DownloadDuration).steadySpeed
+	}
+
+	chunkSize := st.config.DownloadSize / streams
+	if chunkSize <= 0 {
+		return 0
+	}
+
+	var totalBytes atomic.Int64
+	var wg sync.WaitGroup
+	start := time.Now()
+	for i := 0; i < streams; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if dr := st.testDownload(ctx, proxy, chunkSize); dr != nil {
+				totalBytes.Add(dr.bytes)
+			}
+		}()
+	}
+	wg.Wait()
+
+	elapsed := time.Since(start)
+	if elapsed <= 0 {
+		return 0
+	}
+	return float64(totalBytes.Load()) / elapsed.Seconds()
+}
+
+// saturationPoint 返回速度首次达到最高速度 saturationRatio 时的连接数
+func saturationPoint(points []ScalingPoint) int {
+	var maxSpeed float64
+	for _, p := range points {
+		if p.Speed > maxSpeed {
+			maxSpeed = p.Speed
+		}
+	}
+	if maxSpeed <= 0 {
+		return 0
+	}
+	for _, p := range points {
+		if p.Speed >= maxSpeed*saturationRatio {
+			return p.Streams
+		}
+	}
+	return 0
+}
@@ -0,0 +1,24 @@
+package speedtester
+
+import "testing"
+
+func TestSaturationPoint(t *testing.T) {
+	tests := []struct {
+		name   string
+		points []ScalingPoint
+		want   int
+	}{
+		{name: "empty", want: 0},
+		{name: "all failed", points: []ScalingPoint{{1, 0}, {4, 0}}, want: 0},
+		{name: "single stream saturates", points: []ScalingPoint{{1, 950}, {2, 1000}, {4, 980}}, want: 1},
+		{name: "scales up", points: []ScalingPoint{{1, 200}, {2, 450}, {4, 850}, {8, 1000}}, want: 8},
+		{name: "exactly the ratio", points: []ScalingPoint{{1, 200}, {2, 900}, {4, 1000}}, want: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := saturationPoint(tt.points); got != tt.want {
+				t.Errorf("saturationPoint() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,171 @@
+package speedtester
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+)
+
+var errBudgetExhausted = errors.New("traffic budget exhausted")
+
+// scheduler 在整个测试过程中限制所有节点的测速连接总数，并统计消耗的总流量
+type scheduler struct {
+	// 测速连接信号量，为 nil 时不限制
+	streams chan struct{}
+	// 总流量预算，为 0 时不限制
+	budget int64
+	used   atomic.Int64
+
+	mu           sync.Mutex
+	reservations map[*reservation]struct{}
+}
+
+// reservation 是为一个节点的速度测试预留的流量。节点消耗的流量已计入 used，
+// 因此只有尚未消耗的部分仍然占用预算
+type reservation struct {
+	size    int64
+	counter *atomic.Int64
+	start   int64
+}
+
+// outstanding 返回预留流量中尚未消耗的部分
+func (r *reservation) outstanding() int64 {
+	left := r.size - (r.counter.Load() - r.start)
+	if left < 0 {
+		return 0
+	}
+	return left
+}
+
+func newScheduler(maxStreams int, budget int64) *scheduler {
+	s := &scheduler{budget: budget, reservations: make(map[*reservation]struct{})}
+	if maxStreams > 0 {
+		s.streams = make(chan struct{}, maxStreams)
+	}
+	return s
+}
+
+// acquire 获取一个测速连接名额，返回释放函数。context 结束时返回 false
+func (s *scheduler) acquire(ctx context.Context) (func(), bool) {
+	if s.streams == nil {
+		return func() {}, true
+	}
+	select {
+	case s.streams <- struct{}{}:
+		return func() { <-s.streams }, true
+	case <-ctx.Done():
+		return func() {}, false
+	}
+}
+
+// reserve 为即将进行的固定大小测试预留流量，counter 为该节点的流量计数器，
+// 预留的流量随节点消耗逐渐释放。预算不足时返回 false
+func (s *scheduler) reserve(n int64, counter *atomic.Int64) (*reservation, bool) {
+	if s.budget <= 0 {
+		return nil, true
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.used.Load()+s.outstandingLocked()+n > s.budget {
+		return nil, false
+	}
+	r := &reservation{size: n, counter: counter, start: counter.Load()}
+	s.reservations[r] = struct{}{}
+	return r, true
+}
+
+// release 在节点测试结束后释放剩余的预留流量，实际消耗已通过连接统计计入
+func (s *scheduler) release(r *reservation) {
+	if r == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.reservations, r)
+}
+
+func (s *scheduler) outstandingLocked() int64 {
+	var outstanding int64
+	for r := range s.reservations {
+		outstanding += r.outstanding()
+	}
+	return outstanding
+}
+
+// exhausted 判断预算是否已经用完（其他节点尚未消耗的预留流量也视为已使用）
+func (s *scheduler) exhausted() bool {
+	if s.budget <= 0 {
+		return false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.used.Load()+s.outstandingLocked() >= s.budget
+}
+
+// budgetWriter 在流量预算用完后返回错误，用于中止按时长进行的测试
+type budgetWriter struct {
+	io.Writer
+	scheduler *scheduler
+}
+
+func (w *budgetWriter) Write(p []byte) (int, error) {
+	if w.scheduler.exhausted() {
+		return 0, errBudgetExhausted
+	}
+	return w.Writer.Write(p)
+}
+
+// trafficConn 统计经过代理连接的流量，同时计入节点流量和全局流量
+type trafficConn struct {
+	net.Conn
+	node   *atomic.Int64
+	global *atomic.Int64
+}
+
+func (c *trafficConn) Read(b []byte) (int, error) {
+	n, err := c.Conn.Read(b)
+	c.add(n)
+	return n, err
+}
+
+func (c *trafficConn) Write(b []byte) (int, error) {
+	n, err := c.Conn.Write(b)
+	c.add(n)
+	return n, err
+}
+
+func (c *trafficConn) add(n int) {
+	addTraffic(c.node, c.global, n)
+}
+
+// trafficPacketConn 统计经过代理 UDP 中继的流量，与 trafficConn 一样计入节点流量和全局流量
+type trafficPacketConn struct {
+	net.PacketConn
+	node   *atomic.Int64
+	global *atomic.Int64
+}
+
+func (c *trafficPacketConn) ReadFrom(b []byte) (int, net.Addr, error) {
+	n, addr, err := c.PacketConn.ReadFrom(b)
+	addTraffic(c.node, c.global, n)
+	return n, addr, err
+}
+
+func (c *trafficPacketConn) WriteTo(b []byte, addr net.Addr) (int, error) {
+	n, err := c.PacketConn.WriteTo(b, addr)
+	addTraffic(c.node, c.global, n)
+	return n, err
+}
+
+func addTraffic(node, global *atomic.Int64, n int) {
+	if n <= 0 {
+		return
+	}
+	if node != nil {
+		node.Add(int64(n))
+	}
+	global.Add(int64(n))
+}
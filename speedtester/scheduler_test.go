@@ -0,0 +1,150 @@
+package speedtester
+
+import (
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestSchedulerBudget(t *testing.T) {
+	tests := []struct {
+		name string
+		// 依次为每个节点预留 planned 字节并消耗 consumed 字节，测试结束后释放
+		budget        int64
+		planned       int64
+		consumed      []int64
+		wantReserved  []bool
+		wantExhausted bool
+	}{
+		{
+			name:          "unlimited",
+			budget:        0,
+			planned:       100,
+			consumed:      []int64{100, 100, 100},
+			wantReserved:  []bool{true, true, true},
+			wantExhausted: false,
+		},
+		{
+			name:          "consumed bytes are not counted twice",
+			budget:        300,
+			planned:       100,
+			consumed:      []int64{100, 100, 100},
+			wantReserved:  []bool{true, true, true},
+			wantExhausted: true,
+		},
+		{
+			name:          "unused reservation is released",
+			budget:        300,
+			planned:       150,
+			consumed:      []int64{50, 50, 50},
+			wantReserved:  []bool{true, true, true},
+			wantExhausted: false,
+		},
+		{
+			name:          "not enough budget left",
+			budget:        250,
+			planned:       100,
+			consumed:      []int64{100, 100, 0},
+			wantReserved:  []bool{true, true, false},
+			wantExhausted: false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newScheduler(0, tt.budget)
+			for i, consumed := range tt.consumed {
+				var node atomic.Int64
+				r, ok := s.reserve(tt.planned, &node)
+				if ok != tt.wantReserved[i] {
+					t.Fatalf("node %d reserved = %v, want %v", i, ok, tt.wantReserved[i])
+				}
+				if !ok {
+					continue
+				}
+				// 模拟 trafficConn 同时计入节点流量和全局流量
+				node.Add(consumed)
+				s.used.Add(consumed)
+				// 节点自己已消耗的流量只计算一次
+				want := s.budget > 0 && s.used.Load()+max(tt.planned-consumed, 0) >= s.budget
+				if got := s.exhausted(); got != want {
+					t.Fatalf("node %d exhausted = %v at used %d of %d, want %v", i, got, s.used.Load(), s.budget, want)
+				}
+				s.release(r)
+			}
+			if got := s.exhausted(); got != tt.wantExhausted {
+				t.Errorf("exhausted = %v, want %v", got, tt.wantExhausted)
+			}
+		})
+	}
+}
+
+func TestSchedulerConcurrentReservations(t *testing.T) {
+	s := newScheduler(0, 1000)
+	var a, b atomic.Int64
+	ra, ok := s.reserve(400, &a)
+	if !ok {
+		t.Fatal("first reservation failed")
+	}
+	if _, ok := s.reserve(700, &b); ok {
+		t.Fatal("reservation over the budget succeeded")
+	}
+	rb, ok := s.reserve(600, &b)
+	if !ok {
+		t.Fatal("second reservation failed")
+	}
+	if !s.exhausted() {
+		t.Fatal("budget fully reserved but not exhausted")
+	}
+
+	// a 消耗一半后仍占用剩余的预留，预算依然视为用完
+	a.Add(200)
+	s.used.Add(200)
+	if !s.exhausted() {
+		t.Fatal("exhausted = false after partial consumption")
+	}
+	// a 结束后释放剩余的 200 字节
+	s.release(ra)
+	if s.exhausted() {
+		t.Fatal("exhausted = true after releasing the remainder")
+	}
+	s.release(rb)
+}
+
+func TestTrafficPacketConn(t *testing.T) {
+	echo, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer echo.Close()
+	go func() {
+		buf := make([]byte, 2048)
+		for {
+			n, addr, err := echo.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			echo.WriteTo(buf[:n], addr)
+		}
+	}()
+
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var node, global atomic.Int64
+	conn := &trafficPacketConn{PacketConn: pc, node: &node, global: &global}
+	defer conn.Close()
+
+	packet := make([]byte, 100)
+	if _, err := conn.WriteTo(packet, echo.LocalAddr()); err != nil {
+		t.Fatal(err)
+	}
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	if _, _, err := conn.ReadFrom(make([]byte, 2048)); err != nil {
+		t.Fatal(err)
+	}
+	if node.Load() != 200 || global.Load() != 200 {
+		t.Errorf("node = %d, global = %d, want 200 bytes sent and received", node.Load(), global.Load())
+	}
+}
@@ -0,0 +1,74 @@
+package speedtester
+
+import (
+	"context"
+	"time"
+
+	"github.com/metacubex/mihomo/constant"
+)
+
+// 选择测速服务器时对每个服务器的探测次数
+const serverProbeCount = 3
+
+// SpeedServer 是可供选择的测速服务器
+type SpeedServer struct {
+	Name    string
+	Backend SpeedBackend
+}
+
+// selectSpeedServer 为节点选择测速服务器。出口国家在映射表中时直接使用映射的服务器，
+// 否则通过代理测量到每个服务器的延迟并选择最近的一个
+func (st *SpeedTester) selectSpeedServer(proxy constant.Proxy, country string) (*SpeedServer, time.Duration) {
+	if server, ok := st.config.SpeedServerMap[country]; ok {
+		return server, st.serverLatency(proxy, server.Backend)
+	}
+	if len(st.config.SpeedServers) == 0 {
+		return nil, 0
+	}
+
+	best := st.config.SpeedServers[0]
+	var bestLatency time.Duration
+	for _, server := range st.config.SpeedServers {
+		latency := st.serverLatency(proxy, server.Backend)
+		if latency > 0 && (bestLatency == 0 || latency < bestLatency) {
+			best, bestLatency = server, latency
+		}
+	}
+	return best, bestLatency
+}
+
+// serverLatency 通过代理请求测速服务器，返回多次探测中最短的响应时间，全部失败时返回0
+func (st *SpeedTester) serverLatency(proxy constant.Proxy, backend SpeedBackend) time.Duration {
+	client := st.createClient(proxy)
+	var best time.Duration
+	for i := 0; i < serverProbeCount; i++ {
+		ctx, cancel := context.WithTimeout(context.Background(), st.config.Timeout)
+		req, err := backend.NewDownloadRequest(ctx, 1)
+		if err != nil {
+			cancel()
+			return 0
+		}
+		start := time.Now()
+		resp, err := client.Do(req)
+		if err == nil {
+			latency := time.Since(start)
+			resp.Body.Close()
+			if isDownloadStatus(resp.StatusCode) && (best == 0 || latency < best) {
+				best = latency
+			}
+		}
+		cancel()
+	}
+	return best
+}
+
+// withBackend 返回使用指定测速后端的测试器，与原测试器共享调度器和运行信息
+func (st *SpeedTester) withBackend(backend SpeedBackend) *SpeedTester {
+	config := *st.config
+	config.SpeedBackend = backend
+	return &SpeedTester{
+		config:    &config,
+		scheduler: st.scheduler,
+		metadata:  st.metadata,
+	}
+}
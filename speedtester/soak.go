@@ -0,0 +1,96 @@
+package speedtester
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/metacubex/mihomo/constant"
+)
+
+const (
+	// 仅指定持续下载量时的最长测试时间
+	maxSoakDuration = 10 * time.Minute
+	// 降速后的速度不高于降速前的该比例才视为限速
+	throttleRatio = 0.5
+	// 降速需要至少持续的时间，避免把短暂的波动当作限速
+	throttleMinSustain = 2 * time.Second
+)
+
+type throttleResult struct {
+	throttled  bool
+	afterBytes int64
+	speed      float64
+}
+
+// testSoak 持续下载指定时长或指定数据量，记录整个过程的吞吐量变化并检测限速
+func (st *SpeedTester) testSoak(ctx context.Context, proxy constant.Proxy) ([]ThroughputSample, *throttleResult) {
+	duration := st.config.SoakDuration
+	if duration <= 0 {
+		duration = maxSoakDuration
+	}
+
+	samples := sampleThroughput(ctx, duration, st.config.SoakSize, st.config.Concurrent, st.downloadWorker(proxy))
+	return samples, detectThrottling(samples, st.config.DownloadWarmup)
+}
+
+// detectThrottling 在预热窗口之后的采样中寻找一次持续的降速。
+// 对每个可能的分割点计算前后两段的平均速度，取差异最显著的分割点，
+// 若后段平均速度不高于前段的 throttleRatio 且后段绝大部分采样都低于前段水平，则判定为限速
+func detectThrottling(samples []ThroughputSample, warmup time.Duration) *throttleResult {
+	result := &throttleResult{}
+
+	// 跳过预热窗口，同时累计预热阶段的字节数
+	var warmupBytes int64
+	start := 0
+	for start < len(samples) && samples[start].Offset <= warmup {
+		warmupBytes += samples[start].Bytes
+		start++
+	}
+	steady := samples[start:]
+
+	minSegment := int(throttleMinSustain / sampleInterval)
+	n := len(steady)
+	if n < minSegment*2 {
+		return result
+	}
+
+	// 前缀和用于快速计算任意分段的平均速度
+	prefix := make([]float64, n+1)
+	for i, s := range steady {
+		prefix[i+1] = prefix[i] + s.Speed
+	}
+
+	split := -1
+	var bestScore, before, after float64
+	for k := minSegment; k <= n-minSegment; k++ {
+		b := prefix[k] / float64(k)
+		a := (prefix[n] - prefix[k]) / float64(n-k)
+		// 按两段长度加权，避免过短的分段主导结果
+		score := (b - a) * float64(k) * float64(n-k) / float64(n)
+		if score > bestScore {
+			bestScore, split, before, after = score, k, b, a
+		}
+	}
+	if split < 0 || before <= 0 || after > before*throttleRatio {
+		return result
+	}
+
+	// 降速后的 P90 仍明显低于降速前，才视为持续降速而非偶发波动
+	tail := make([]float64, 0, n-split)
+	for _, s := range steady[split:] {
+		tail = append(tail, s.Speed)
+	}
+	sort.Float64s(tail)
+	if percentile(tail, 90) > before*(1+throttleRatio)/2 {
+		return result
+	}
+
+	result.throttled = true
+	result.afterBytes = warmupBytes
+	for _, s := range steady[:split] {
+		result.afterBytes += s.Bytes
+	}
+	result.speed = after
+	return result
+}
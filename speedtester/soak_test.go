@@ -0,0 +1,77 @@
+package speedtester
+
+import (
+	"testing"
+	"time"
+)
+
+// repeatSpeed 返回 n 个相同的速度
+func repeatSpeed(speed float64, n int) []float64 {
+	speeds := make([]float64, n)
+	for i := range speeds {
+		speeds[i] = speed
+	}
+	return speeds
+}
+
+func concatSpeeds(parts ...[]float64) []float64 {
+	var speeds []float64
+	for _, part := range parts {
+		speeds = append(speeds, part...)
+	}
+	return speeds
+}
+
+func TestDetectThrottling(t *testing.T) {
+	tests := []struct {
+		name       string
+		speeds     []float64
+		warmup     time.Duration
+		throttled  bool
+		afterBytes int64
+		speed      float64
+	}{
+		{
+			name:   "steady",
+			speeds: repeatSpeed(1000, 40),
+		},
+		{
+			name:   "too few samples",
+			speeds: concatSpeeds(repeatSpeed(1000, 5), repeatSpeed(100, 5)),
+		},
+		{
+			name:   "mild slowdown",
+			speeds: concatSpeeds(repeatSpeed(1000, 20), repeatSpeed(700, 20)),
+		},
+		{
+			name:   "brief dip recovers",
+			speeds: concatSpeeds(repeatSpeed(1000, 16), repeatSpeed(100, 8), repeatSpeed(1000, 16)),
+		},
+		{
+			name:       "throttled",
+			speeds:     concatSpeeds(repeatSpeed(1000, 20), repeatSpeed(200, 20)),
+			throttled:  true,
+			afterBytes: 5000,
+			speed:      200,
+		},
+		{
+			name:       "warmup bytes counted before throttling",
+			speeds:     concatSpeeds(repeatSpeed(5000, 4), repeatSpeed(1000, 16), repeatSpeed(200, 16)),
+			warmup:     time.Second,
+			throttled:  true,
+			afterBytes: 9000,
+			speed:      200,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := detectThrottling(uniformSamples(tt.speeds...), tt.warmup)
+			if result.throttled != tt.throttled {
+				t.Fatalf("throttled = %v, want %v", result.throttled, tt.throttled)
+			}
+			if result.afterBytes != tt.afterBytes || result.speed != tt.speed {
+				t.Errorf("afterBytes = %d speed = %v, want %d %v", result.afterBytes, result.speed, tt.afterBytes, tt.speed)
+			}
+		})
+	}
+}
@@ -1,15 +1,13 @@
 package speedtester
 
 import (
-	"bytes"
 	"context"
+	"crypto/tls"
 	"encoding/json"
 	"fmt"
 	"io"
-	"io/ioutil"
 	"math"
 	"math/rand"
-	"mime/multipart"
 	"net"
 	"net/http"
 	"os"
@@ -18,9 +16,11 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/faceair/clash-speedtest/unlock"
+	"github.com/faceair/clash-speedtest/utils"
 	"github.com/metacubex/mihomo/adapter"
 	"github.com/metacubex/mihomo/adapter/provider"
 	"github.com/metacubex/mihomo/constant"
@@ -28,24 +28,68 @@ import (
 	"gopkg.in/yaml.v3"
 )
 
+// 默认的延迟测试探测地址
+const latencyTestURL = "https://www.gstatic.com/generate_204"
+
 type Config struct {
 	ConfigPaths      string
 	FilterRegex      string
 	ServerURL        string
+	LatencyURL       string
+	Insecure         bool
 	DownloadSize     int
+	DownloadDuration time.Duration
+	DownloadWarmup   time.Duration
 	UploadSize       int
+	SoakDuration     time.Duration
+	SoakSize         int64
 	Timeout          time.Duration
 	Concurrent       int
+	ScalingStreams   []int
 	TestConcurrent   int
+	Rounds           int
+	RoundInterval    time.Duration
+	MaxVariation     float64
+	MaxStreams       int
+	// 所有节点的总流量预算，包括测速、UDP 和抖动测试经过代理的流量，为 0 时不限制
+	TrafficBudget    int64
 	UnlockTest       string
 	Fast             bool
+	LoadedLatency    bool
+	UDPTest          bool
+	UDPServer        string
+	JitterURL        string
+	JitterDuration   time.Duration
+	JitterInterval   time.Duration
+	EntryProbe       bool
+	ExpandDNS        bool
+	Via              string
+	ViaMatrix        bool
+	Baseline         bool
+	RouteCheck       bool
+	Payload          string
+	ReceiptKey       string
 	MaxLatency       time.Duration
 	MinDownloadSpeed float64
 	MinUploadSpeed   float64
+	// UDP 丢包率和延迟测试请求失败率的上限（%），超过时跳过速度测试，为 0 时不限制
+	MaxPacketLoss  float64
+	MaxFailureRate float64
+
+	// 中国大陆连通性检测器，为空时从本机直接进行 TCP 探测
+	ReachabilityChecker ReachabilityChecker
+	// 测速后端，为空时按 Cloudflare 接口访问 ServerURL
+	SpeedBackend SpeedBackend
+	// 可供选择的测速服务器，以及出口国家代码到测速服务器的映射
+	SpeedServers   []*SpeedServer
+	SpeedServerMap map[string]*SpeedServer
 }
 
 type SpeedTester struct {
-	config *Config
+	config       *Config
+	scheduler    *scheduler
+	metadata     *RunMetadata
+	baselineOnce sync.Once
 }
 
 func New(config *Config) *SpeedTester {
@@ -61,14 +105,49 @@ func New(config *Config) *SpeedTester {
 	if config.TestConcurrent <= 0 {
 		config.TestConcurrent = 2
 	}
+	if config.ReachabilityChecker == nil {
+		config.ReachabilityChecker = &TCPReachabilityChecker{Timeout: config.Timeout}
+	}
+	if config.SpeedBackend == nil {
+		config.SpeedBackend = &CloudflareBackend{URL: config.ServerURL}
+	}
+	if config.LatencyURL == "" {
+		config.LatencyURL = latencyTestURL
+	}
+	if config.UDPServer == "" {
+		config.UDPServer = "1.1.1.1:53"
+	}
+	// 按连接数从小到大进行扩展测试，便于找到饱和点
+	sort.Ints(config.ScalingStreams)
 	return &SpeedTester{
-		config: config,
+		config:    config,
+		scheduler: newScheduler(config.MaxStreams, config.TrafficBudget),
+		metadata: &RunMetadata{
+			StartedAt:  time.Now(),
+			ServerURL:  config.ServerURL,
+			Backend:    config.SpeedBackend.Name(),
+			LatencyURL: config.LatencyURL,
+		},
 	}
 }
 
 type CProxy struct {
 	constant.Proxy
 	Config map[string]any
+	// 由 -expand-dns 展开的节点记录原始的服务器域名
+	ParentServer string
+	// 经过该节点的累计流量
+	traffic atomic.Int64
+	// 最近一次拨号实际连接的服务器 IP
+	dialedIP atomic.Value
+	// 通过 -via 链式测试时的前置节点，以及前置节点和落地节点的名称
+	Relay       *CProxy
+	RelayName   string
+	LandingName string
+	// 节点来自的配置文件路径或订阅链接
+	Source string
+	// 节点来自 Proxy Provider 时为 Provider 的名称，此时 Config 是 Provider 的配置而不是节点的配置
+	Provider string
 }
 
 type RawConfig struct {
@@ -76,6 +155,11 @@ type RawConfig struct {
 	Proxies   []map[string]any          `yaml:"proxies"`
 }
 
+// TrafficUsed 返回本次运行通过代理消耗的总流量
+func (st *SpeedTester) TrafficUsed() int64 {
+	return st.scheduler.used.Load()
+}
+
 func (st *SpeedTester) GetDefaultClient() *http.Client {
 	return &http.Client{
 		Timeout: 5 * time.Second,
@@ -83,9 +167,31 @@ func (st *SpeedTester) GetDefaultClient() *http.Client {
 }
 
 func (st *SpeedTester) LoadProxies() (map[string]*CProxy, error) {
+	allProxies, err := st.loadProxies(st.config.ConfigPaths)
+	if err != nil {
+		return nil, err
+	}
+
+	filterRegexp := regexp.MustCompile(st.config.FilterRegex)
+	filteredProxies := make(map[string]*CProxy)
+	for name := range allProxies {
+		if filterRegexp.MatchString(name) {
+			filteredProxies[name] = allProxies[name]
+		}
+	}
+
+	// 通过前置节点测试落地节点
+	if st.config.Via != "" {
+		return st.chainProxies(filteredProxies, allProxies)
+	}
+	return filteredProxies, nil
+}
+
+// loadProxies 从逗号分隔的配置文件或订阅链接中加载所有支持的节点
+func (st *SpeedTester) loadProxies(configPaths string) (map[string]*CProxy, error) {
 	allProxies := make(map[string]*CProxy)
 
-	for _, configPath := range strings.Split(st.config.ConfigPaths, ",") {
+	for _, configPath := range strings.Split(configPaths, ",") {
 		var body []byte
 		var err error
 		if strings.HasPrefix(configPath, "http") {
@@ -121,8 +227,19 @@ func (st *SpeedTester) LoadProxies() (map[string]*CProxy, error) {
 		// 过滤掉无效的节点
 		proxiesConfig = filterInvalidProxies(proxiesConfig)
 
-		for i, config := range proxiesConfig {
-			proxy, err := adapter.ParseProxy(config)
+		// 将服务器域名的每个解析地址展开为单独的节点
+		var expanded []expandedProxy
+		if st.config.ExpandDNS {
+			expanded = expandDNSProxies(proxiesConfig)
+		} else {
+			expanded = make([]expandedProxy, 0, len(proxiesConfig))
+			for _, config := range proxiesConfig {
+				expanded = append(expanded, expandedProxy{config: config})
+			}
+		}
+
+		for i, ep := range expanded {
+			proxy, err := adapter.ParseProxy(ep.config)
 			if err != nil {
 				fmt.Println(fmt.Errorf("proxy %d: %w", i, err))
 				continue
@@ -131,7 +248,7 @@ func (st *SpeedTester) LoadProxies() (map[string]*CProxy, error) {
 			if _, exist := proxies[proxy.Name()]; exist {
 				return nil, fmt.Errorf("proxy %s is the duplicate name", proxy.Name())
 			}
-			proxies[proxy.Name()] = &CProxy{Proxy: proxy, Config: config}
+			proxies[proxy.Name()] = &CProxy{Proxy: proxy, Config: ep.config, ParentServer: ep.parent, Source: configPath}
 		}
 
 		for name, config := range providersConfig {
@@ -146,7 +263,7 @@ func (st *SpeedTester) LoadProxies() (map[string]*CProxy, error) {
 				return nil, fmt.Errorf("initial proxy provider %s error: %w", pd.Name(), err)
 			}
 			for _, proxy := range pd.Proxies() {
-				proxies[fmt.Sprintf("[%s] %s", name, proxy.Name())] = &CProxy{Proxy: proxy, Config: config}
+				proxies[fmt.Sprintf("[%s] %s", name, proxy.Name())] = &CProxy{Proxy: proxy, Config: config, Source: configPath, Provider: name}
 			}
 		}
 		for k, p := range proxies {
@@ -162,18 +279,21 @@ func (st *SpeedTester) LoadProxies() (map[string]*CProxy, error) {
 			}
 		}
 	}
-
-	filterRegexp := regexp.MustCompile(st.config.FilterRegex)
-	filteredProxies := make(map[string]*CProxy)
-	for name := range allProxies {
-		if filterRegexp.MatchString(name) {
-			filteredProxies[name] = allProxies[name]
-		}
-	}
-	return filteredProxies, nil
+	return allProxies, nil
 }
 
 func (st *SpeedTester) TestProxies(proxies map[string]*CProxy, fn func(result *Result)) {
+	st.TestProxiesContext(context.Background(), proxies, fn)
+}
+
+// TestProxiesContext 与 TestProxies 相同，context 结束后不再开始新的节点测试，
+// 已经开始的节点会完成测试并正常回调，返回 context 的错误
+func (st *SpeedTester) TestProxiesContext(ctx context.Context, proxies map[string]*CProxy, fn func(result *Result)) error {
+	// 在测试节点前进行一次直连测试作为基准
+	if st.config.Baseline {
+		st.baselineOnce.Do(func() { st.runBaseline(ctx) })
+	}
+
 	ch := make(chan *Result, len(proxies))
 
 	// 创建一个信号量来控制并发数
@@ -182,24 +302,37 @@ func (st *SpeedTester) TestProxies(proxies map[string]*CProxy, fn func(result *R
 	// 启动goroutine进行测试
 	for name, proxy := range proxies {
 		go func(name string, proxy *CProxy) {
-			// 获取信号量
-			sem <- struct{}{}
+			// 获取信号量，取消后跳过尚未开始的节点
+			select {
+			case sem <- struct{}{}:
+			case <-ctx.Done():
+				ch <- nil
+				return
+			}
 			// 测试完成后释放信号量
 			defer func() { <-sem }()
+			if ctx.Err() != nil {
+				ch <- nil
+				return
+			}
 
 			// 执行测试并将结果发送到通道
-			ch <- st.testProxy(name, proxy)
+			result := st.testProxyRounds(ctx, name, proxy)
+			st.applyBaseline(result)
+			ch <- result
 		}(name, proxy)
 	}
 
 	// 收集所有结果
 	for i := 0; i < len(proxies); i++ {
-		result := <-ch
-		fn(result)
+		if result := <-ch; result != nil {
+			fn(result)
+		}
 	}
 
 	// 关闭通道
 	close(ch)
+	return ctx.Err()
 }
 
 type testJob struct {
@@ -222,6 +355,102 @@ type Result struct {
 	UploadSpeed   float64                  `json:"upload_speed"`
 	UnlockResults map[string]*UnlockResult `json:"unlock_results,omitempty"`
 	IpInfoResult  IpInfo                   `json:"ip_info,omitempty"`
+
+	// HTTP 延迟测试的请求失败率，以及服务器在中国大陆的连通性。
+	// 只有检测结论为 blocked 时 CNReachable 才为 false，检测失败的原因记录在 CNCheckError 中
+	RequestFailureRate float64             `json:"request_failure_rate"`
+	CNReachable        bool                `json:"cn_reachable"`
+	CNCheckVerdict     ReachabilityVerdict `json:"cn_check_verdict"`
+	CNCheckError       string              `json:"cn_check_error,omitempty"`
+
+	// 不经过代理直接连接节点服务器的入口延迟和 TLS 证书信息
+	EntryRTT           time.Duration `json:"entry_rtt,omitempty"`
+	EntryTLSSubject    string        `json:"entry_tls_subject,omitempty"`
+	EntryTLSExpiry     time.Time     `json:"entry_tls_expiry,omitempty"`
+	EntryTLSSelfSigned bool          `json:"entry_tls_self_signed,omitempty"`
+	EntryError         string        `json:"entry_error,omitempty"`
+
+	// UDP 中继测试结果，丢包率记录在 PacketLoss 中
+	UDPSupported bool          `json:"udp_supported"`
+	UDPLatency   time.Duration `json:"udp_latency,omitempty"`
+
+	// 按时长测试下载时的峰值、P10速度以及逐区间采样，可用于绘制图表
+	DownloadPeakSpeed float64            `json:"download_peak_speed,omitempty"`
+	DownloadP10Speed  float64            `json:"download_p10_speed,omitempty"`
+	DownloadSamples   []ThroughputSample `json:"download_samples,omitempty"`
+
+	// 持续下载测试的采样以及限速检测结果
+	SoakSamples         []ThroughputSample `json:"soak_samples,omitempty"`
+	Throttled           bool               `json:"throttled,omitempty"`
+	ThrottledAfterBytes int64              `json:"throttled_after_bytes,omitempty"`
+	ThrottledSpeed      float64            `json:"throttled_speed,omitempty"`
+
+	// 空闲与负载状态下的延迟对比（bufferbloat）
+	IdleLatency      time.Duration `json:"idle_latency,omitempty"`
+	LoadedLatency    time.Duration `json:"loaded_latency,omitempty"`
+	LatencyIncrease  time.Duration `json:"latency_increase,omitempty"`
+	BufferbloatGrade string        `json:"bufferbloat_grade,omitempty"`
+
+	// 本节点消耗的流量，以及是否因流量预算用完而跳过了速度测试
+	TrafficUsed     int64 `json:"traffic_used"`
+	BudgetExhausted bool  `json:"budget_exhausted,omitempty"`
+
+	// 多轮测试的统计结果，延迟、抖动、丢包率和速度字段此时为各轮的中位数
+	Rounds         int                     `json:"rounds,omitempty"`
+	RoundStats     map[string]*MetricStats `json:"round_stats,omitempty"`
+	StabilityScore float64                 `json:"stability_score,omitempty"`
+	Unstable       bool                    `json:"unstable,omitempty"`
+
+	// 不同并发连接数下的下载速度曲线
+	ScalingResults    []ScalingPoint `json:"scaling_results,omitempty"`
+	SingleStreamSpeed float64        `json:"single_stream_speed,omitempty"`
+	SaturationStreams int            `json:"saturation_streams,omitempty"`
+
+	// 由 -expand-dns 展开的节点所属的服务器域名
+	ParentServer string `json:"parent_server,omitempty"`
+	// 代理连接实际拨号的服务器 IP
+	DialedIP string `json:"dialed_ip,omitempty"`
+
+	// 通过 -via 链式测试时的前置节点和落地节点
+	Relay   string `json:"relay,omitempty"`
+	Landing string `json:"landing,omitempty"`
+
+	// 相对于直连基准保留的下载速度百分比，以及增加的延迟
+	SpeedRetained float64       `json:"speed_retained,omitempty"`
+	ExtraLatency  time.Duration `json:"extra_latency,omitempty"`
+
+	// 入口地址与出口 IP 的归属信息，以及据此判断的线路类型
+	EntryIP      string    `json:"entry_ip,omitempty"`
+	EntryCountry string    `json:"entry_country,omitempty"`
+	EntryASN     string    `json:"entry_asn,omitempty"`
+	ExitASN      string    `json:"exit_asn,omitempty"`
+	RouteType    RouteType `json:"route_type,omitempty"`
+	RouteError   string    `json:"route_error,omitempty"`
+
+	// 为该节点选择的测速服务器，以及通过节点到该服务器的延迟
+	SpeedServer        string        `json:"speed_server,omitempty"`
+	SpeedServerLatency time.Duration `json:"speed_server_latency,omitempty"`
+
+	// 测速服务器自行计时得到的上传速度，UploadSpeed 为客户端计时的结果。
+	// 代理缓冲上传数据时客户端测得的速度会偏高
+	ServerUploadSpeed     float64 `json:"server_upload_speed,omitempty"`
+	UploadReceiptVerified bool    `json:"upload_receipt_verified,omitempty"`
+
+	// 在一条长连接上定速发送探测包得到的 RFC 3550 到达间隔抖动、往返延迟中位数、丢包率和乱序包数
+	InterarrivalJitter time.Duration `json:"interarrival_jitter,omitempty"`
+	JitterRTT          time.Duration `json:"jitter_rtt,omitempty"`
+	JitterLoss         float64       `json:"jitter_loss,omitempty"`
+	JitterOutOfOrder   int           `json:"jitter_out_of_order,omitempty"`
+	JitterError        string        `json:"jitter_error,omitempty"`
+
+	// 节点来自的配置文件路径或订阅链接
+	Source string `json:"source,omitempty"`
+	// 节点来自的 Proxy Provider，ProxyConfig 为 Provider 的配置
+	Provider string `json:"provider,omitempty"`
+
+	// 本轮是否进行了下载和上传测试，多轮聚合时只统计进行了测试的轮次
+	downloadTested bool
+	uploadTested   bool
 }
 
 type UnlockResult struct {
@@ -244,6 +473,131 @@ func (r *Result) FormatDownloadSpeed() string {
 	return formatSpeed(r.DownloadSpeed)
 }
 
+func (r *Result) FormatDownloadPeakSpeed() string {
+	return formatSpeed(r.DownloadPeakSpeed)
+}
+
+func (r *Result) FormatDownloadP10Speed() string {
+	return formatSpeed(r.DownloadP10Speed)
+}
+
+func (r *Result) FormatThrottle() string {
+	if !r.Throttled {
+		return "否"
+	}
+	return fmt.Sprintf("%s后降至%s", FormatSize(r.ThrottledAfterBytes), formatSpeed(r.ThrottledSpeed))
+}
+
+func (r *Result) FormatLoadedLatency() string {
+	if r.LoadedLatency == 0 {
+		return "N/A"
+	}
+	return fmt.Sprintf("%dms(+%dms %s)", r.LoadedLatency.Milliseconds(), r.LatencyIncrease.Milliseconds(), r.BufferbloatGrade)
+}
+
+func (r *Result) FormatSingleStreamSpeed() string {
+	return formatSpeed(r.SingleStreamSpeed)
+}
+
+func (r *Result) FormatSaturation() string {
+	if r.SaturationStreams == 0 {
+		return "N/A"
+	}
+	return fmt.Sprintf("%d", r.SaturationStreams)
+}
+
+func (r *Result) FormatTrafficUsed() string {
+	return FormatSize(r.TrafficUsed)
+}
+
+func (r *Result) FormatStability() string {
+	if r.Rounds <= 1 {
+		return "N/A"
+	}
+	if r.Unstable {
+		return fmt.Sprintf("%.0f(波动大)", r.StabilityScore)
+	}
+	return fmt.Sprintf("%.0f", r.StabilityScore)
+}
+
+func (r *Result) FormatUDP() string {
+	if !r.UDPSupported {
+		return "不支持"
+	}
+	if r.UDPLatency == 0 {
+		return "N/A"
+	}
+	return fmt.Sprintf("%dms", r.UDPLatency.Milliseconds())
+}
+
+func (r *Result) FormatEntryRTT() string {
+	if r.EntryRTT == 0 {
+		return "N/A"
+	}
+	return fmt.Sprintf("%dms", r.EntryRTT.Milliseconds())
+}
+
+func (r *Result) FormatEntryTLS() string {
+	if r.EntryTLSSubject == "" {
+		return "N/A"
+	}
+	info := "到期" + r.EntryTLSExpiry.Format("2006-01-02")
+	if r.EntryTLSSelfSigned {
+		info = "自签 " + info
+	}
+	return info
+}
+
+func (r *Result) FormatOverhead() string {
+	if r.SpeedRetained == 0 && r.ExtraLatency == 0 {
+		return "N/A"
+	}
+	retained := "N/A"
+	if r.SpeedRetained > 0 {
+		retained = fmt.Sprintf("%.0f%%", r.SpeedRetained)
+	}
+	return fmt.Sprintf("%s / %+dms", retained, r.ExtraLatency.Milliseconds())
+}
+
+func (r *Result) FormatRoute() string {
+	switch r.RouteType {
+	case RouteDirect:
+		return "直连"
+	case RouteRelayed:
+		return "中转"
+	case RouteCrossASN:
+		return "跨ASN"
+	case RouteTransit:
+		return "跨境中转"
+	default:
+		return "N/A"
+	}
+}
+
+func (r *Result) FormatEntryCountry() string {
+	if r.EntryCountry == "" {
+		return "N/A"
+	}
+	if name, ok := utils.CountryCodeMap[r.EntryCountry]; ok {
+		return name
+	}
+	return r.EntryCountry
+}
+
+func (r *Result) FormatInterarrivalJitter() string {
+	if r.JitterRTT == 0 {
+		return "N/A"
+	}
+	return fmt.Sprintf("%.1fms", float64(r.InterarrivalJitter)/float64(time.Millisecond))
+}
+
+func (r *Result) FormatServerUploadSpeed() string {
+	if r.ServerUploadSpeed == 0 {
+		return "N/A"
+	}
+	return formatSpeed(r.ServerUploadSpeed)
+}
+
 func (r *Result) FormatLatency() string {
 	if r.Latency == 0 {
 		return "N/A"
@@ -258,10 +612,31 @@ func (r *Result) FormatJitter() string {
 	return fmt.Sprintf("%dms", r.Jitter.Milliseconds())
 }
 
+// FormatPacketLoss 丢包率只有在 UDP 测试收到回包时才有意义
 func (r *Result) FormatPacketLoss() string {
+	if !r.UDPSupported {
+		return "N/A"
+	}
 	return fmt.Sprintf("%.1f%%", r.PacketLoss)
 }
 
+func (r *Result) FormatRequestFailureRate() string {
+	return fmt.Sprintf("%.1f%%", r.RequestFailureRate)
+}
+
+func (r *Result) FormatCNReachable() string {
+	switch r.CNCheckVerdict {
+	case VerdictReachable:
+		return "可达"
+	case VerdictBlocked:
+		return "被墙"
+	case VerdictDisabled:
+		return "未检测"
+	default:
+		return "未知"
+	}
+}
+
 func (r *Result) FormatUploadSpeed() string {
 	return formatSpeed(r.UploadSpeed)
 }
@@ -277,22 +652,87 @@ func formatSpeed(bytesPerSecond float64) string {
 	return fmt.Sprintf("%.2f%s", speed, units[unit])
 }
 
-func (st *SpeedTester) testProxy(name string, proxy *CProxy) *Result {
+// FormatSize 将字节数格式化为带单位的字符串
+func FormatSize(bytes int64) string {
+	units := []string{"B", "KB", "MB", "GB", "TB"}
+	unit := 0
+	size := float64(bytes)
+	for size >= 1024 && unit < len(units)-1 {
+		size /= 1024
+		unit++
+	}
+	return fmt.Sprintf("%.1f%s", size, units[unit])
+}
+
+// ParseSize 解析带单位的流量大小，例如 500MB、20GB，不带单位时按字节处理
+func ParseSize(value string) (int64, error) {
+	value = strings.ToUpper(strings.TrimSpace(value))
+	if value == "" {
+		return 0, nil
+	}
+	units := []struct {
+		suffix string
+		size   float64
+	}{
+		{"TB", 1 << 40},
+		{"GB", 1 << 30},
+		{"MB", 1 << 20},
+		{"KB", 1 << 10},
+		{"B", 1},
+	}
+	multiplier := float64(1)
+	for _, unit := range units {
+		if strings.HasSuffix(value, unit.suffix) {
+			multiplier = unit.size
+			value = strings.TrimSpace(strings.TrimSuffix(value, unit.suffix))
+			break
+		}
+	}
+	n, err := strconv.ParseFloat(value, 64)
+	if err != nil {
+		return 0, err
+	}
+	if n < 0 {
+		return 0, fmt.Errorf("invalid size: %s", value)
+	}
+	return int64(n * multiplier), nil
+}
+
+// testProxy 测试单个节点，ctx 结束后中止按时长进行的下载测试并跳过剩余的测试
+func (st *SpeedTester) testProxy(ctx context.Context, name string, proxy *CProxy) *Result {
 	result := &Result{
-		ProxyName:   name,
-		ProxyType:   proxy.Type().String(),
-		ProxyConfig: proxy.Config,
+		ProxyName:    name,
+		ProxyType:    proxy.Type().String(),
+		ProxyConfig:  proxy.Config,
+		ParentServer: proxy.ParentServer,
+		Relay:        proxy.RelayName,
+		Landing:      proxy.LandingName,
+		Source:       proxy.Source,
+		Provider:     proxy.Provider,
 	}
 
+	// 统计本节点在本次测试中消耗的流量
+	trafficStart := proxy.traffic.Load()
+	defer func() {
+		result.TrafficUsed = proxy.traffic.Load() - trafficStart
+		if ip, ok := proxy.dialedIP.Load().(string); ok {
+			result.DialedIP = ip
+		}
+	}()
+
 	// 1. 首先进行延迟测试
 	latencyResult := st.testLatency(proxy)
 	result.Latency = latencyResult.avgLatency
 	result.Jitter = latencyResult.jitter
-	result.PacketLoss = latencyResult.packetLoss
+	result.RequestFailureRate = latencyResult.failureRate
+	result.CNCheckVerdict = latencyResult.cnVerdict
+	if latencyResult.cnErr != nil {
+		result.CNCheckError = latencyResult.cnErr.Error()
+	}
+	result.CNReachable = result.CNCheckVerdict != VerdictBlocked
 
-	// 如果延迟测试完全失败或中国联通性检测失败，直接返回
-	// 中国联通性检测失败时，packetLoss会被设置为100%
-	if result.PacketLoss >= 50 {
+	// 如果延迟测试大部分失败或中国联通性检测失败，直接返回
+	if result.RequestFailureRate >= 50 || !result.CNReachable {
 		return result
 	}
 
@@ -308,6 +748,39 @@ func (st *SpeedTester) testProxy(name string, proxy *CProxy) *Result {
 	unlockResultChan := make(chan map[string]*unlock.StreamResult, 1)
 	// 创建通道用于接收IP信息获取结果
 	ipInfoResultChan := make(chan *unlock.IpInfo, 1)
+	// 创建通道用于接收UDP测试结果
+	udpResultChan := make(chan *udpResult, 1)
+	// 创建通道用于接收入口探测结果
+	entryResultChan := make(chan *entryResult, 1)
+	// 创建通道用于接收抖动测试结果
+	jitterResultChan := make(chan *jitterResult, 1)
+
+	// 启动入口探测
+	if st.config.EntryProbe {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			entryResultChan <- st.testEntry(proxy)
+		}()
+	}
+
+	// 启动UDP中继测试
+	if st.config.UDPTest {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			udpResultChan <- st.testUDP(proxy)
+		}()
+	}
+
+	// 启动长连接抖动测试
+	if st.config.JitterURL != "" && st.config.JitterDuration > 0 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			jitterResultChan <- st.testJitter(proxy)
+		}()
+	}
 
 	// 启动流媒体解锁测试
 	if st.config.UnlockTest != "" {
@@ -329,9 +802,14 @@ func (st *SpeedTester) testProxy(name string, proxy *CProxy) *Result {
 		ipInfoResult, err := unlock.GetLocationWithRisk(st.createClient(proxy), false, true)
 		if err == nil && ipInfoResult != nil {
 			ipInfoResultChan <- ipInfoResult
-		} else {
-			ipInfoResultChan <- &unlock.IpInfo{}
+			return
 		}
+		// 出口信息查询失败时从测速服务器的 /cdn-cgi/trace 获取出口 IP
+		if ip, err := st.traceExitIP(proxy); err == nil {
+			ipInfoResultChan <- &unlock.IpInfo{Ip: ip}
+			return
+		}
+		ipInfoResultChan <- &unlock.IpInfo{}
 	}()
 
 	// 等待所有并发任务完成
@@ -342,6 +820,38 @@ func (st *SpeedTester) testProxy(name string, proxy *CProxy) *Result {
 		close(unlockResultChan)
 	}
 	close(ipInfoResultChan)
+	close(udpResultChan)
+	close(entryResultChan)
+	close(jitterResultChan)
+
+	// 处理入口探测结果
+	if entryResult := <-entryResultChan; entryResult != nil {
+		result.EntryRTT = entryResult.rtt
+		result.EntryTLSSubject = entryResult.tlsSubject
+		result.EntryTLSExpiry = entryResult.tlsExpiry
+		result.EntryTLSSelfSigned = entryResult.selfSigned
+		if entryResult.err != nil {
+			result.EntryError = entryResult.err.Error()
+		}
+	}
+
+	// 处理UDP测试结果
+	if udpResult := <-udpResultChan; udpResult != nil {
+		result.UDPSupported = udpResult.supported
+		result.UDPLatency = udpResult.latency
+		result.PacketLoss = udpResult.packetLoss
+	}
+
+	// 处理抖动测试结果
+	if jitterResult := <-jitterResultChan; jitterResult != nil {
+		result.InterarrivalJitter = jitterResult.jitter
+		result.JitterRTT = jitterResult.rtt
+		result.JitterLoss = jitterResult.loss
+		result.JitterOutOfOrder = jitterResult.outOfOrder
+		if jitterResult.err != nil {
+			result.JitterError = jitterResult.err.Error()
+		}
+	}
 
 	// 处理流媒体解锁测试结果
 	if st.config.UnlockTest != "" {
@@ -366,95 +876,270 @@ func (st *SpeedTester) testProxy(name string, proxy *CProxy) *Result {
 		City:        ipInfoResult.City,
 		RiskInfo:    ipInfoResult.RiskInfo,
 	}
+
+	// 比较入口与出口 IP，判断是否经过中转
+	if st.config.RouteCheck {
+		route := st.testRoute(proxy, result.IpInfoResult.Ip)
+		result.EntryIP = route.entryIP
+		result.EntryCountry = route.entryCountry
+		result.EntryASN = route.entryASN
+		result.ExitASN = route.exitASN
+		result.RouteType = route.routeType
+		if route.err != nil {
+			result.RouteError = route.err.Error()
+		}
+	}
+	// 丢包率或请求失败率超过上限的节点会被过滤，跳过下载和上传测试以节省流量
+	if st.exceedsLossLimits(result) {
+		return result
+	}
+
 	// 如果是Fast模式，跳过下载和上传测试
 	if st.config.Fast {
 		return result
 	}
 
-	// 4. 并发进行下载和上传测试
+	// 配置了多个测速服务器时，按出口国家或延迟为节点选择测速服务器
+	if server, latency := st.selectSpeedServer(proxy, result.IpInfoResult.Country); server != nil {
+		result.SpeedServer = server.Name
+		result.SpeedServerLatency = latency
+		st = st.withBackend(server.Backend)
+	}
+
+	// 流量预算不足时退回Fast模式，只保留延迟等测试结果
+	planned := st.plannedTraffic()
+	if st.scheduler.exhausted() {
+		result.BudgetExhausted = true
+		return result
+	}
+	reservation, ok := st.scheduler.reserve(planned, &proxy.traffic)
+	if !ok {
+		result.BudgetExhausted = true
+		return result
+	}
+	defer st.scheduler.release(reservation)
+
+	// 在下载和上传测试期间持续探测延迟，上传测试结束后写入结果，不包含之后的并发和持续下载测试
+	var probe *latencyProbe
+	if st.config.LoadedLatency {
+		probe = st.startLatencyProbe(proxy)
+	}
+	finishProbe := func() {
+		if probe != nil {
+			probe.finish(result)
+			probe = nil
+		}
+	}
+	defer finishProbe()
+
+	// 4. 依次进行下载和上传测试
+	result.downloadTested = true
+	if !st.testDownloadStage(ctx, proxy, result) {
+		return result
+	}
+	result.uploadTested = true
+	if !st.testUploadStage(ctx, proxy, result) {
+		return result
+	}
+	finishProbe()
+	if st.config.SpeedBackend.SupportsUpload() && result.UploadSpeed < st.config.MinUploadSpeed {
+		return result
+	}
+
+	// 5. 使用不同的并发连接数测试下载速度，找到单连接速度和饱和点
+	if len(st.config.ScalingStreams) > 0 && ctx.Err() == nil {
+		result.ScalingResults = st.testScaling(ctx, proxy)
+		for _, p := range result.ScalingResults {
+			if p.Streams == 1 {
+				result.SingleStreamSpeed = p.Speed
+			}
+		}
+		result.SaturationStreams = saturationPoint(result.ScalingResults)
+	}
+
+	// 6. 持续下载测试，检测节点是否在一定流量后限速
+	if (st.config.SoakDuration > 0 || st.config.SoakSize > 0) && ctx.Err() == nil {
+		samples, throttle := st.testSoak(ctx, proxy)
+		result.SoakSamples = samples
+		result.Throttled = throttle.throttled
+		result.ThrottledAfterBytes = throttle.afterBytes
+		result.ThrottledSpeed = throttle.speed
+	}
+
+	return result
+}
 
-	var wg1 sync.WaitGroup
+// exceedsLossLimits 判断节点的 UDP 丢包率或请求失败率是否超过配置的上限，丢包率只在 UDP 测试收到回包时参与判断
+func (st *SpeedTester) exceedsLossLimits(result *Result) bool {
+	if st.config.MaxPacketLoss > 0 && result.UDPSupported && result.PacketLoss > st.config.MaxPacketLoss {
+		return true
+	}
+	return st.config.MaxFailureRate > 0 && result.RequestFailureRate > st.config.MaxFailureRate
+}
 
-	var totalDownloadBytes, totalUploadBytes int64
-	var totalDownloadTime, totalUploadTime time.Duration
-	var downloadCount, uploadCount int
+// testDownloadStage 按配置进行下载测试并写入结果，下载速度低于要求时返回 false
+func (st *SpeedTester) testDownloadStage(ctx context.Context, proxy constant.Proxy, result *Result) bool {
+	var wg sync.WaitGroup
 
 	downloadChunkSize := st.config.DownloadSize / st.config.Concurrent
-	if downloadChunkSize > 0 {
+	if st.config.DownloadDuration > 0 {
+		// 按时长测试下载，使用墙钟时间计算稳态速度
+		tr := st.testDownloadDuration(ctx, proxy, st.config.Concurrent, st.config.DownloadDuration)
+		result.DownloadSize = float64(tr.bytes)
+		result.DownloadTime = tr.duration
+		result.DownloadSpeed = tr.steadySpeed
+		result.DownloadPeakSpeed = tr.peakSpeed
+		result.DownloadP10Speed = tr.p10Speed
+		result.DownloadSamples = tr.samples
+
+		if ctx.Err() != nil || result.DownloadSpeed < st.config.MinDownloadSpeed {
+			return false
+		}
+	} else if downloadChunkSize > 0 {
 		downloadResults := make(chan *downloadResult, st.config.Concurrent)
 
 		for i := 0; i < st.config.Concurrent; i++ {
-			wg1.Add(1)
+			wg.Add(1)
 			go func() {
-				defer wg1.Done()
-				downloadResults <- st.testDownload(proxy, downloadChunkSize)
+				defer wg.Done()
+				downloadResults <- st.testDownload(ctx, proxy, downloadChunkSize)
 			}()
 		}
-		wg1.Wait()
+		wg.Wait()
+		// 取消后的传输只完成了一部分，不计入结果
+		if ctx.Err() != nil {
+			return false
+		}
 
+		transfers := make([]*downloadResult, 0, st.config.Concurrent)
 		for i := 0; i < st.config.Concurrent; i++ {
 			if dr := <-downloadResults; dr != nil {
-				totalDownloadBytes += dr.bytes
-				totalDownloadTime += dr.duration
-				downloadCount++
+				transfers = append(transfers, dr)
 			}
 		}
 		close(downloadResults)
 
-		if downloadCount > 0 {
-			result.DownloadSize = float64(totalDownloadBytes)
-			result.DownloadTime = totalDownloadTime / time.Duration(downloadCount)
-			result.DownloadSpeed = float64(totalDownloadBytes) / result.DownloadTime.Seconds()
+		// 按墙钟时间计算所有连接的合计速度
+		if bytes, elapsed := sumTransfers(transfers); elapsed > 0 {
+			result.DownloadSize = float64(bytes)
+			result.DownloadTime = elapsed
+			result.DownloadSpeed = float64(bytes) / elapsed.Seconds()
 		}
 
 		if result.DownloadSpeed < st.config.MinDownloadSpeed {
-			return result
+			return false
 		}
 	}
 
+	return true
+}
+
+// testUploadStage 按配置进行上传测试并写入结果，context 结束时返回 false
+func (st *SpeedTester) testUploadStage(ctx context.Context, proxy constant.Proxy, result *Result) bool {
+	var wg sync.WaitGroup
+	var serverUploadBytes int64
+	var serverUploadTime time.Duration
+	var receiptCount int
+	allVerified := true
+
+	// 只支持下载的后端跳过上传测试
+	if !st.config.SpeedBackend.SupportsUpload() {
+		return true
+	}
+	if ctx.Err() != nil {
+		return false
+	}
+
 	uploadChunkSize := st.config.UploadSize / st.config.Concurrent
 	if uploadChunkSize > 0 {
 		uploadResults := make(chan *downloadResult, st.config.Concurrent)
 
 		for i := 0; i < st.config.Concurrent; i++ {
-			wg1.Add(1)
+			wg.Add(1)
 			go func() {
-				defer wg1.Done()
-				uploadResults <- st.testUpload(proxy, uploadChunkSize)
+				defer wg.Done()
+				uploadResults <- st.testUpload(ctx, proxy, uploadChunkSize)
 			}()
 		}
-		wg1.Wait()
+		wg.Wait()
+		if ctx.Err() != nil {
+			return false
+		}
 
+		transfers := make([]*downloadResult, 0, st.config.Concurrent)
 		for i := 0; i < st.config.Concurrent; i++ {
 			if ur := <-uploadResults; ur != nil {
-				totalUploadBytes += ur.bytes
-				totalUploadTime += ur.duration
-				uploadCount++
+				transfers = append(transfers, ur)
+
+				// 配置了签名密钥时只采信校验通过的回执
+				if ur.receipt == nil {
+					continue
+				}
+				if st.config.ReceiptKey != "" && !ur.receiptVerified {
+					allVerified = false
+					continue
+				}
+				serverUploadBytes += ur.receipt.Bytes
+				// 各连接同时上传，服务端没有统一的时钟，以耗时最长的连接近似墙钟时间
+				serverUploadTime = max(serverUploadTime, ur.receipt.Duration)
+				receiptCount++
 			}
 		}
 		close(uploadResults)
 
-		if uploadCount > 0 {
-			result.UploadSize = float64(totalUploadBytes)
-			result.UploadTime = totalUploadTime / time.Duration(uploadCount)
-			result.UploadSpeed = float64(totalUploadBytes) / result.UploadTime.Seconds()
+		if bytes, elapsed := sumTransfers(transfers); elapsed > 0 {
+			result.UploadSize = float64(bytes)
+			result.UploadTime = elapsed
+			result.UploadSpeed = float64(bytes) / elapsed.Seconds()
 		}
-
-		if result.UploadSpeed < st.config.MinUploadSpeed {
-			return result
+		if receiptCount > 0 && serverUploadTime > 0 {
+			result.ServerUploadSpeed = float64(serverUploadBytes) / serverUploadTime.Seconds()
+			result.UploadReceiptVerified = st.config.ReceiptKey != "" && allVerified
 		}
 	}
+	return true
+}
 
-	return result
+// plannedTraffic 估算单个节点固定大小测速所需的流量，按时长进行的测试无法预估，由实时统计控制
+func (st *SpeedTester) plannedTraffic() int64 {
+	var planned int64
+	if st.config.DownloadDuration <= 0 {
+		planned += int64(st.config.DownloadSize) * int64(1+len(st.config.ScalingStreams))
+	}
+	if st.config.SpeedBackend.SupportsUpload() {
+		planned += int64(st.config.UploadSize)
+	}
+	planned += st.config.SoakSize
+	return planned
 }
 
 type latencyResult struct {
-	avgLatency time.Duration
-	jitter     time.Duration
-	packetLoss float64
+	avgLatency  time.Duration
+	jitter      time.Duration
+	failureRate float64
+	cnVerdict   ReachabilityVerdict
+	cnErr       error
 }
 
 func (st *SpeedTester) testLatency(proxy *CProxy) *latencyResult {
+	// 测试server的中国连通性，与延迟测试同时进行
+	var cnVerdict ReachabilityVerdict
+	var cnErr error
+	cnDone := make(chan struct{})
+	go func() {
+		defer close(cnDone)
+		cnVerdict, cnErr = st.checkCNNetwork(proxy)
+	}()
+
+	result := st.pingLatency(proxy)
+	<-cnDone
+	result.cnVerdict = cnVerdict
+	result.cnErr = cnErr
+	return result
+}
+
+// pingLatency 通过代理多次请求延迟探测地址，统计延迟、抖动和请求失败率
+func (st *SpeedTester) pingLatency(proxy constant.Proxy) *latencyResult {
 	client := st.createClient(proxy)
 	failedPings := 0
 	var failedPingsMutex sync.Mutex
@@ -471,7 +1156,7 @@ func (st *SpeedTester) testLatency(proxy *CProxy) *latencyResult {
 
 			start := time.Now()
 			// resp, err := client.Get(fmt.Sprintf("%s/__down?bytes=0", st.config.ServerURL))
-			resp, err := client.Get("https://www.gstatic.com/generate_204")
+			resp, err := client.Get(st.config.LatencyURL)
 			// resp, err := client.Get("http://www.gstatic.com/generate_204")
 			if err != nil {
 				failedPingsMutex.Lock()
@@ -490,15 +1175,6 @@ func (st *SpeedTester) testLatency(proxy *CProxy) *latencyResult {
 		}()
 	}
 
-	// 测试server的中国连通性
-	if !st.checkCNNetwork(proxy) {
-		// 直接返回表示中国连通性失败的结果
-		return &latencyResult{
-			packetLoss: 100, // 设置为100%丢包率表示完全不可用
-			avgLatency: 0,
-			jitter:     0,
-		}
-	}
 	// 等待所有ping测试完成
 	wg.Wait()
 	// 获取最终的failedPings值用于计算
@@ -514,17 +1190,48 @@ func (st *SpeedTester) testLatency(proxy *CProxy) *latencyResult {
 }
 
 type downloadResult struct {
-	bytes    int64
-	duration time.Duration
+	bytes int64
+	// 连接开始传输和传输结束的时间
+	start, end time.Time
+	// 测速服务器返回的上传回执，以及签名是否校验通过
+	receipt         *UploadReceipt
+	receiptVerified bool
 }
 
-func (st *SpeedTester) checkCNNetwork(proxy *CProxy) bool {
-	// 获取服务器地址,如果是域名则解析IP
+// sumTransfers 返回并发连接的合计字节数，以及从最早开始到最晚结束的墙钟时间。
+// 不使用各连接耗时的平均值，否则合计字节数除以单个连接的耗时会高估速度。
+// 等待并发名额的时间不计入，避免其他节点占用名额时低估速度
+func sumTransfers(transfers []*downloadResult) (int64, time.Duration) {
+	var bytes int64
+	var first, last time.Time
+	for _, t := range transfers {
+		bytes += t.bytes
+		if first.IsZero() || t.start.Before(first) {
+			first = t.start
+		}
+		if t.end.After(last) {
+			last = t.end
+		}
+	}
+	if first.IsZero() {
+		return 0, 0
+	}
+	return bytes, last.Sub(first)
+}
+
+// serverAddress 返回节点的服务器地址和端口，域名会被解析为第一个IPv4地址
+func serverAddress(proxy *CProxy) (string, string) {
 	server := getString(proxy.Config, "server")
 	port := getString(proxy.Config, "port")
+	if server == "" {
+		// 来自 proxy-provider 的节点没有单独的配置，使用适配器记录的地址
+		if host, p, err := net.SplitHostPort(proxy.Addr()); err == nil {
+			server, port = host, p
+		}
+	}
 	if server != "" {
 		// 检查是否为域名
-		if ips, err := net.LookupIP(server); err == nil {
+		if ips, err := lookupIP(server); err == nil {
 			// 如果能成功解析IP,则使用第一个IP地址
 			for _, ip := range ips {
 				if ipv4 := ip.To4(); ipv4 != nil {
@@ -533,100 +1240,94 @@ func (st *SpeedTester) checkCNNetwork(proxy *CProxy) bool {
 				}
 			}
 		}
-		// fmt.Println(checkCnWallBy204(client))
-		return checkCnWall(server, port, st.GetDefaultClient())
 	}
-
-	client := st.createClient(proxy)
-	return checkCnWallBy204(client)
+	return server, port
 }
-func checkCnWallBy204(client *http.Client) bool {
-	url := "https://connectivitycheck.platform.hicloud.com/generate_204"
-	method := "GET"
 
-	req, _ := http.NewRequest(method, url, nil)
-
-	req.Header.Set("Host", "connectivitycheck.platform.hicloud.com")
-	res, err := client.Do(req)
-	if err != nil {
-		return false
+// proxyNetwork 返回代理与服务器之间使用的传输层协议
+func proxyNetwork(proxy constant.Proxy) string {
+	switch proxy.Type() {
+	case constant.Hysteria, constant.Hysteria2, constant.Tuic, constant.WireGuard:
+		return "udp"
+	default:
+		return "tcp"
 	}
-	defer res.Body.Close()
-	return res.StatusCode == http.StatusOK || res.StatusCode == http.StatusNoContent
 }
 
-func checkCnWall(ip string, port string, client *http.Client) bool {
-	url := "https://api.ycwxgzs.com/ipcheck/index.php"
-	method := "POST"
-
-	payload := &bytes.Buffer{}
-	writer := multipart.NewWriter(payload)
-	_ = writer.WriteField("ip", ip)
-	_ = writer.WriteField("port", port)
-	_ = writer.Close()
-
-	req, _ := http.NewRequest(method, url, payload)
-	req.Header.Set("Content-Type", writer.FormDataContentType())
-	res, err := client.Do(req)
-	if err != nil {
-		return false
-	}
-	defer res.Body.Close()
-	body, err := ioutil.ReadAll(res.Body)
-	if err != nil {
-		return false
-	}
-	// fmt.Println("\nbody", string(body))
-	// 尝试解析新的JSON响应格式
-	type NetworkCheckResponse struct {
-		Ip   string `json:"ip"`
-		Port string `json:"port"`
-		Tcp  string `json:"tcp"`
-		Icmp string `json:"icmp"`
+func (st *SpeedTester) checkCNNetwork(proxy *CProxy) (ReachabilityVerdict, error) {
+	// 链式测试时从本机直接连接的是前置节点
+	proxy = proxy.entryProxy()
+	server, port := serverAddress(proxy)
+	if server == "" {
+		return VerdictUnknown, fmt.Errorf("proxy has no server address")
 	}
 
-	// 解析JSON响应
-	var response NetworkCheckResponse
-	if err := json.Unmarshal(body, &response); err != nil {
-		return false
+	ctx := context.Background()
+	if st.config.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, st.config.Timeout)
+		defer cancel()
 	}
-	// fmt.Println("\nresponse", response)
-	// 检查TCP或ICMP是否包含"不可用"字样
-	return !strings.Contains(response.Tcp, "不可用")
+	return st.config.ReachabilityChecker.Check(ctx, ReachabilityTarget{
+		Server:  server,
+		Port:    port,
+		Network: proxyNetwork(proxy),
+	})
 }
 
-func (st *SpeedTester) testDownload(proxy constant.Proxy, size int) *downloadResult {
+func (st *SpeedTester) testDownload(ctx context.Context, proxy constant.Proxy, size int) *downloadResult {
 	client := st.createClientWithTimeout(proxy, st.config.Timeout)
+	release, ok := st.scheduler.acquire(ctx)
+	defer release()
+	if !ok {
+		return nil
+	}
 	start := time.Now()
 
-	resp, err := client.Get(fmt.Sprintf("%s/__down?bytes=%d", st.config.ServerURL, size))
+	req, err := st.newDownloadRequest(ctx, size)
+	if err != nil {
+		return nil
+	}
+	resp, err := client.Do(req)
 	if err != nil {
 		return nil
 	}
 	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
+	if !isDownloadStatus(resp.StatusCode) {
 		return nil
 	}
 
-	downloadBytes, _ := io.Copy(io.Discard, resp.Body)
+	// 静态文件可能大于需要的大小，只读取 size 字节
+	downloadBytes, _ := io.Copy(io.Discard, io.LimitReader(resp.Body, int64(size)))
 
 	return &downloadResult{
-		bytes:    downloadBytes,
-		duration: time.Since(start),
+		bytes: downloadBytes,
+		start: start,
+		end:   time.Now(),
 	}
 }
 
-func (st *SpeedTester) testUpload(proxy constant.Proxy, size int) *downloadResult {
+func (st *SpeedTester) testUpload(ctx context.Context, proxy constant.Proxy, size int) *downloadResult {
 	client := st.createClientWithTimeout(proxy, st.config.Timeout)
-	reader := NewZeroReader(size)
+	reader, err := NewPayloadReader(st.config.Payload, size)
+	if err != nil {
+		return nil
+	}
+	release, ok := st.scheduler.acquire(ctx)
+	defer release()
+	if !ok {
+		return nil
+	}
 
 	start := time.Now()
-	resp, err := client.Post(
-		fmt.Sprintf("%s/__up", st.config.ServerURL),
-		"application/octet-stream",
-		reader,
-	)
+	req, err := st.config.SpeedBackend.NewUploadRequest(ctx, reader, size)
+	if err != nil {
+		return nil
+	}
+	nonce := newUploadNonce()
+	req.Header.Set(UploadNonceHeader, nonce)
+	resp, err := client.Do(req)
 	if err != nil {
 		return nil
 	}
@@ -636,42 +1337,83 @@ func (st *SpeedTester) testUpload(proxy constant.Proxy, size int) *downloadResul
 		return nil
 	}
 
-	return &downloadResult{
-		bytes:    reader.WrittenBytes(),
-		duration: time.Since(start),
+	result := &downloadResult{
+		bytes: reader.WrittenBytes(),
+		start: start,
+		end:   time.Now(),
+	}
+	// download-server 会返回自己计时的上传回执，其他测速服务器没有回执
+	receipt := &UploadReceipt{}
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 4096)).Decode(receipt); err == nil && receipt.Duration > 0 {
+		result.receipt = receipt
+		result.receiptVerified = st.config.ReceiptKey != "" && receipt.Verify(st.config.ReceiptKey, nonce)
+	}
+	return result
+}
+
+// dialContext 返回通过代理建立 TCP 连接的拨号函数，同时统计流量并记录实际拨号的服务器 IP
+func (st *SpeedTester) dialContext(proxy constant.Proxy) func(ctx context.Context, network, addr string) (net.Conn, error) {
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		host, port, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, err
+		}
+		var u16Port uint16
+		if port, err := strconv.ParseUint(port, 10, 16); err == nil {
+			u16Port = uint16(port)
+		}
+
+		// Create metadata with a larger buffer size hint for VLESS Vision protocol
+		metadata := &constant.Metadata{
+			Host:    host,
+			DstPort: u16Port,
+		}
+
+		// Check if this is a VLESS proxy with Vision protocol
+		if cProxy, ok := proxy.(*CProxy); ok && cProxy.Type() == constant.Vless {
+			if flow, ok := cProxy.Config["flow"].(string); ok && strings.Contains(strings.ToLower(flow), "vision") {
+				// Set a special option to increase buffer size for Vision protocol
+				metadata.SpecialProxy = "vision-large-buffer"
+			}
+		}
+
+		conn, err := proxy.DialContext(ctx, metadata)
+		if err != nil {
+			return nil, err
+		}
+		// 统计流量，同时计入节点流量和全局流量
+		var node *atomic.Int64
+		if cProxy, ok := proxy.(*CProxy); ok {
+			node = &cProxy.traffic
+			// 代理连接的远端地址即为实际拨号的服务器地址
+			if host, _, err := net.SplitHostPort(conn.RemoteAddr().String()); err == nil && net.ParseIP(host) != nil {
+				cProxy.dialedIP.Store(host)
+			}
+		}
+		return &trafficConn{Conn: conn, node: node, global: &st.scheduler.used}, nil
+	}
+}
+
+// listenPacket 通过代理建立 UDP 中继，同时统计流量
+func (st *SpeedTester) listenPacket(ctx context.Context, proxy constant.Proxy, metadata *constant.Metadata) (net.PacketConn, error) {
+	pc, err := proxy.ListenPacketContext(ctx, metadata)
+	if err != nil {
+		return nil, err
+	}
+	var node *atomic.Int64
+	if cProxy, ok := proxy.(*CProxy); ok {
+		node = &cProxy.traffic
 	}
+	return &trafficPacketConn{PacketConn: pc, node: node, global: &st.scheduler.used}, nil
 }
 
 func (st *SpeedTester) createClientWithTimeout(proxy constant.Proxy, timeout time.Duration) *http.Client {
 	return &http.Client{
 		Timeout: timeout,
 		Transport: &http.Transport{
-			DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
-				host, port, err := net.SplitHostPort(addr)
-				if err != nil {
-					return nil, err
-				}
-				var u16Port uint16
-				if port, err := strconv.ParseUint(port, 10, 16); err == nil {
-					u16Port = uint16(port)
-				}
-
-				// Create metadata with a larger buffer size hint for VLESS Vision protocol
-				metadata := &constant.Metadata{
-					Host:    host,
-					DstPort: u16Port,
-				}
-
-				// Check if this is a VLESS proxy with Vision protocol
-				if cProxy, ok := proxy.(*CProxy); ok && cProxy.Type() == constant.Vless {
-					if flow, ok := cProxy.Config["flow"].(string); ok && strings.Contains(strings.ToLower(flow), "vision") {
-						// Set a special option to increase buffer size for Vision protocol
-						metadata.SpecialProxy = "vision-large-buffer"
-					}
-				}
-
-				return proxy.DialContext(ctx, metadata)
-			},
+			DialContext: st.dialContext(proxy),
+			// 自建测速服务器使用自签名证书时跳过证书校验
+			TLSClientConfig: &tls.Config{InsecureSkipVerify: st.config.Insecure},
 			// Add these settings to improve stability
 			MaxIdleConns:          100,
 			IdleConnTimeout:       90 * time.Second,
@@ -686,7 +1428,7 @@ func (st *SpeedTester) createClient(proxy constant.Proxy) *http.Client {
 }
 func calculateLatencyStats(latencies []time.Duration, failedPings int) *latencyResult {
 	result := &latencyResult{
-		packetLoss: float64(failedPings) / 20.0 * 100,
+		failureRate: float64(failedPings) / 20.0 * 100,
 	}
 
 	if len(latencies) == 0 {
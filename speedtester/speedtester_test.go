@@ -0,0 +1,165 @@
+package speedtester
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/metacubex/mihomo/adapter"
+	"github.com/metacubex/mihomo/adapter/outbound"
+)
+
+func TestSumTransfers(t *testing.T) {
+	base := time.Unix(1700000000, 0)
+	transfer := func(bytes int64, start, end time.Duration) *downloadResult {
+		return &downloadResult{bytes: bytes, start: base.Add(start), end: base.Add(end)}
+	}
+	tests := []struct {
+		name      string
+		transfers []*downloadResult
+		wantBytes int64
+		wantTime  time.Duration
+	}{
+		{name: "empty"},
+		{name: "single", transfers: []*downloadResult{transfer(100, 0, time.Second)}, wantBytes: 100, wantTime: time.Second},
+		{
+			name:      "concurrent streams",
+			transfers: []*downloadResult{transfer(100, 0, time.Second), transfer(100, 0, time.Second)},
+			wantBytes: 200,
+			wantTime:  time.Second,
+		},
+		{
+			// 平均耗时为 1s，但从第一个连接开始到最后一个连接结束用了 2s
+			name:      "staggered streams use wall clock",
+			transfers: []*downloadResult{transfer(100, 0, time.Second), transfer(100, time.Second, 2*time.Second)},
+			wantBytes: 200,
+			wantTime:  2 * time.Second,
+		},
+		{
+			name:      "order does not matter",
+			transfers: []*downloadResult{transfer(50, 500*time.Millisecond, 3*time.Second), transfer(50, 0, time.Second)},
+			wantBytes: 100,
+			wantTime:  3 * time.Second,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			bytes, elapsed := sumTransfers(tt.transfers)
+			if bytes != tt.wantBytes || elapsed != tt.wantTime {
+				t.Errorf("sumTransfers() = %d, %s, want %d, %s", bytes, elapsed, tt.wantBytes, tt.wantTime)
+			}
+		})
+	}
+}
+
+// stallingServer 先返回一部分数据，然后一直等到请求被取消
+func stallingServer(t *testing.T, uploads *atomic.Int32) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			uploads.Add(1)
+		}
+		w.WriteHeader(http.StatusOK)
+		w.Write(make([]byte, 1024))
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func TestFixedSizeTransferCanceled(t *testing.T) {
+	var uploads atomic.Int32
+	srv := stallingServer(t, &uploads)
+	st := New(&Config{
+		ServerURL:    srv.URL,
+		Concurrent:   2,
+		DownloadSize: 1024 * 1024,
+		UploadSize:   1024 * 1024,
+		Timeout:      10 * time.Second,
+	})
+	proxy := &CProxy{Proxy: adapter.NewProxy(outbound.NewDirect())}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	result := &Result{}
+	start := time.Now()
+	if st.testDownloadStage(ctx, proxy, result) {
+		t.Error("testDownloadStage() = true after cancel, want false")
+	}
+	// 取消后不应等到超时才返回，部分传输也不计入结果
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("testDownloadStage() took %s after cancel", elapsed)
+	}
+	if result.DownloadSpeed != 0 || result.DownloadSize != 0 {
+		t.Errorf("download speed = %v, size = %v, want 0 after cancel", result.DownloadSpeed, result.DownloadSize)
+	}
+
+	// 已取消的 context 不再发起上传
+	if st.testUploadStage(ctx, proxy, result) {
+		t.Error("testUploadStage() = true after cancel, want false")
+	}
+	if n := uploads.Load(); n != 0 {
+		t.Errorf("upload requests = %d after cancel, want 0", n)
+	}
+}
+
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    int64
+		wantErr bool
+	}{
+		{value: "", want: 0},
+		{value: "1024", want: 1024},
+		{value: "512B", want: 512},
+		{value: "2KB", want: 2 << 10},
+		{value: "500MB", want: 500 << 20},
+		{value: " 1.5gb ", want: 3 << 29},
+		{value: "1TB", want: 1 << 40},
+		{value: "10 MB", want: 10 << 20},
+		{value: "-1GB", wantErr: true},
+		{value: "abc", wantErr: true},
+		{value: "MB", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			got, err := ParseSize(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseSize(%q) error = %v, wantErr %v", tt.value, err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("ParseSize(%q) = %d, want %d", tt.value, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExceedsLossLimits(t *testing.T) {
+	tests := []struct {
+		name           string
+		maxPacketLoss  float64
+		maxFailureRate float64
+		result         Result
+		want           bool
+	}{
+		{name: "no limits", result: Result{UDPSupported: true, PacketLoss: 50, RequestFailureRate: 40}, want: false},
+		{name: "packet loss over limit", maxPacketLoss: 10, result: Result{UDPSupported: true, PacketLoss: 20}, want: true},
+		{name: "packet loss within limit", maxPacketLoss: 10, result: Result{UDPSupported: true, PacketLoss: 10}, want: false},
+		// 没有收到 UDP 回包时丢包率不参与判断
+		{name: "udp unsupported", maxPacketLoss: 10, result: Result{PacketLoss: 100}, want: false},
+		{name: "failure rate over limit", maxFailureRate: 10, result: Result{RequestFailureRate: 20}, want: true},
+		{name: "failure rate within limit", maxFailureRate: 10, result: Result{RequestFailureRate: 10}, want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			st := &SpeedTester{config: &Config{MaxPacketLoss: tt.maxPacketLoss, MaxFailureRate: tt.maxFailureRate}}
+			if got := st.exceedsLossLimits(&tt.result); got != tt.want {
+				t.Errorf("exceedsLossLimits() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,230 @@
+package speedtester
+
+import (
+	"context"
+	"io"
+	"math"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/metacubex/mihomo/constant"
+)
+
+// 吞吐量采样间隔
+const sampleInterval = 250 * time.Millisecond
+
+// ThroughputSample 表示一个采样区间内所有连接的合计吞吐量
+type ThroughputSample struct {
+	Offset time.Duration `json:"offset"`
+	Bytes  int64         `json:"bytes"`
+	Speed  float64       `json:"speed"`
+}
+
+type throughputResult struct {
+	bytes       int64
+	duration    time.Duration
+	steadySpeed float64
+	peakSpeed   float64
+	p10Speed    float64
+	samples     []ThroughputSample
+}
+
+// countingWriter 将写入的字节数累加到共享计数器
+type countingWriter struct {
+	counter *atomic.Int64
+}
+
+func (w *countingWriter) Write(p []byte) (int, error) {
+	w.counter.Add(int64(len(p)))
+	return len(p), nil
+}
+
+// sampleThroughput 在 duration 时间内并发运行 streams 个 worker，
+// 每隔 sampleInterval 记录一次所有 worker 的合计字节数。
+// limit 大于0时，合计字节数达到 limit 后提前结束，parent 结束时同样提前结束
+func sampleThroughput(parent context.Context, duration time.Duration, limit int64, streams int, worker func(ctx context.Context, w io.Writer)) []ThroughputSample {
+	ctx, cancel := context.WithTimeout(parent, duration)
+	defer cancel()
+
+	var counter atomic.Int64
+	var wg sync.WaitGroup
+	for i := 0; i < streams; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			worker(ctx, &countingWriter{counter: &counter})
+		}()
+	}
+
+	// 所有 worker 提前退出时停止采样
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	start := time.Now()
+	ticker := time.NewTicker(sampleInterval)
+	defer ticker.Stop()
+
+	samples := make([]ThroughputSample, 0, int(duration/sampleInterval)+1)
+	var last int64
+	lastTime := start
+	record := func(now time.Time) {
+		total := counter.Load()
+		elapsed := now.Sub(lastTime)
+		if elapsed <= 0 {
+			return
+		}
+		delta := total - last
+		samples = append(samples, ThroughputSample{
+			Offset: now.Sub(start),
+			Bytes:  delta,
+			Speed:  float64(delta) / elapsed.Seconds(),
+		})
+		last = total
+		lastTime = now
+	}
+	// 结束时的最后一个采样区间可能很短，速度会出现尖峰或接近0，
+	// 不足半个采样间隔时合并到上一个采样中
+	recordTail := func(now time.Time) {
+		if len(samples) == 0 || now.Sub(lastTime) >= sampleInterval/2 {
+			record(now)
+			return
+		}
+		mergeTailSample(samples, counter.Load()-last, now.Sub(start))
+	}
+
+	for {
+		select {
+		case now := <-ticker.C:
+			record(now)
+			if limit > 0 && last >= limit {
+				cancel()
+			}
+		case <-ctx.Done():
+			<-done
+			recordTail(time.Now())
+			return samples
+		case <-done:
+			recordTail(time.Now())
+			return samples
+		}
+	}
+}
+
+// mergeTailSample 将结束时的剩余字节合并到最后一个采样，并按合并后的区间重新计算速度
+func mergeTailSample(samples []ThroughputSample, bytes int64, offset time.Duration) {
+	lastSample := &samples[len(samples)-1]
+	prevOffset := time.Duration(0)
+	if len(samples) > 1 {
+		prevOffset = samples[len(samples)-2].Offset
+	}
+	lastSample.Bytes += bytes
+	lastSample.Offset = offset
+	if elapsed := offset - prevOffset; elapsed > 0 {
+		lastSample.Speed = float64(lastSample.Bytes) / elapsed.Seconds()
+	}
+}
+
+// summarizeThroughput 丢弃预热窗口内的采样，基于墙钟时间计算稳态、峰值和P10吞吐量。
+// 连接提前结束导致测试时长不超过预热窗口时，预热窗口缩短为测试时长的一半
+func summarizeThroughput(samples []ThroughputSample, warmup time.Duration) *throughputResult {
+	result := &throughputResult{samples: samples}
+	if len(samples) == 0 {
+		return result
+	}
+
+	for _, s := range samples {
+		result.bytes += s.Bytes
+	}
+	result.duration = samples[len(samples)-1].Offset
+	if warmup >= result.duration {
+		warmup = result.duration / 2
+	}
+
+	// 找到第一个完全位于预热窗口之后的采样
+	steady := samples
+	steadyStart := time.Duration(0)
+	for i, s := range samples {
+		if s.Offset > warmup {
+			if i > 0 {
+				steadyStart = samples[i-1].Offset
+			}
+			steady = samples[i:]
+			break
+		}
+	}
+
+	var steadyBytes int64
+	speeds := make([]float64, 0, len(steady))
+	for _, s := range steady {
+		steadyBytes += s.Bytes
+		speeds = append(speeds, s.Speed)
+		if s.Speed > result.peakSpeed {
+			result.peakSpeed = s.Speed
+		}
+	}
+	if window := result.duration - steadyStart; window > 0 {
+		result.steadySpeed = float64(steadyBytes) / window.Seconds()
+	}
+
+	sort.Float64s(speeds)
+	result.p10Speed = percentile(speeds, 10)
+
+	return result
+}
+
+// percentile 按最近秩法返回已排序切片的第 p 百分位数
+func percentile(sorted []float64, p float64) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	idx := int(math.Ceil(float64(len(sorted))*p/100)) - 1
+	if idx < 0 {
+		idx = 0
+	}
+	return sorted[idx]
+}
+
+// testDownloadDuration 在固定时长内进行多连接下载测试
+func (st *SpeedTester) testDownloadDuration(ctx context.Context, proxy constant.Proxy, streams int, duration time.Duration) *throughputResult {
+	samples := sampleThroughput(ctx, duration, 0, streams, st.downloadWorker(proxy))
+	return summarizeThroughput(samples, st.config.DownloadWarmup)
+}
+
+// downloadWorker 返回一个持续下载直到 context 结束的 worker
+func (st *SpeedTester) downloadWorker(proxy constant.Proxy) func(ctx context.Context, w io.Writer) {
+	// 时长由 context 控制，客户端本身不设置超时
+	client := st.createClientWithTimeout(proxy, 0)
+
+	return func(ctx context.Context, w io.Writer) {
+		release, ok := st.scheduler.acquire(ctx)
+		if !ok {
+			return
+		}
+		defer release()
+
+		// 流量预算用完时立即中止下载
+		w = &budgetWriter{Writer: w, scheduler: st.scheduler}
+		// 单次请求下载完毕后继续发起新的请求，直到时间耗尽或流量预算用完
+		for ctx.Err() == nil && !st.scheduler.exhausted() {
+			req, err := st.newDownloadRequest(ctx, st.config.DownloadSize)
+			if err != nil {
+				return
+			}
+			resp, err := client.Do(req)
+			if err != nil {
+				return
+			}
+			if !isDownloadStatus(resp.StatusCode) {
+				resp.Body.Close()
+				return
+			}
+			io.Copy(w, io.LimitReader(resp.Body, int64(st.config.DownloadSize)))
+			resp.Body.Close()
+		}
+	}
+}
@@ -0,0 +1,149 @@
+package speedtester
+
+import (
+	"context"
+	"io"
+	"testing"
+	"time"
+)
+
+// uniformSamples 生成间隔为 sampleInterval 的采样，speeds 单位为字节/秒
+func uniformSamples(speeds ...float64) []ThroughputSample {
+	samples := make([]ThroughputSample, 0, len(speeds))
+	for i, speed := range speeds {
+		samples = append(samples, ThroughputSample{
+			Offset: time.Duration(i+1) * sampleInterval,
+			Bytes:  int64(speed * sampleInterval.Seconds()),
+			Speed:  speed,
+		})
+	}
+	return samples
+}
+
+func TestSummarizeThroughput(t *testing.T) {
+	tests := []struct {
+		name       string
+		samples    []ThroughputSample
+		warmup     time.Duration
+		wantBytes  int64
+		wantSteady float64
+		wantPeak   float64
+		wantP10    float64
+	}{
+		{
+			name:    "empty",
+			samples: nil,
+			warmup:  time.Second,
+		},
+		{
+			name:       "no warmup",
+			samples:    uniformSamples(400, 800, 1200, 1600),
+			wantBytes:  1000,
+			wantSteady: 1000,
+			wantPeak:   1600,
+			wantP10:    400,
+		},
+		{
+			name:       "warmup dropped",
+			samples:    uniformSamples(4000, 4000, 800, 800, 800, 800),
+			warmup:     2 * sampleInterval,
+			wantBytes:  2800,
+			wantSteady: 800,
+			wantPeak:   800,
+			wantP10:    800,
+		},
+		{
+			name:       "warmup longer than test is clamped to half",
+			samples:    uniformSamples(4000, 4000, 800, 800),
+			warmup:     10 * time.Second,
+			wantBytes:  2400,
+			wantSteady: 800,
+			wantPeak:   800,
+			wantP10:    800,
+		},
+		{
+			name:       "p10 uses nearest rank",
+			samples:    uniformSamples(100, 200, 300, 400, 500, 600, 700, 800, 900, 1000, 1100, 1200),
+			wantBytes:  1950,
+			wantSteady: 650,
+			wantPeak:   1200,
+			wantP10:    200,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := summarizeThroughput(tt.samples, tt.warmup)
+			if result.bytes != tt.wantBytes {
+				t.Errorf("bytes = %d, want %d", result.bytes, tt.wantBytes)
+			}
+			if result.steadySpeed != tt.wantSteady {
+				t.Errorf("steadySpeed = %v, want %v", result.steadySpeed, tt.wantSteady)
+			}
+			if result.peakSpeed != tt.wantPeak {
+				t.Errorf("peakSpeed = %v, want %v", result.peakSpeed, tt.wantPeak)
+			}
+			if result.p10Speed != tt.wantP10 {
+				t.Errorf("p10Speed = %v, want %v", result.p10Speed, tt.wantP10)
+			}
+		})
+	}
+}
+
+func TestMergeTailSample(t *testing.T) {
+	tests := []struct {
+		name      string
+		samples   []ThroughputSample
+		bytes     int64
+		offset    time.Duration
+		wantBytes int64
+		wantSpeed float64
+	}{
+		{
+			name:      "merged into the only sample",
+			samples:   uniformSamples(1000),
+			bytes:     10,
+			offset:    sampleInterval + 10*time.Millisecond,
+			wantBytes: 260,
+			wantSpeed: 1000,
+		},
+		{
+			name:      "speed uses the merged interval",
+			samples:   uniformSamples(1000, 1000),
+			bytes:     0,
+			offset:    2*sampleInterval + 250*time.Millisecond,
+			wantBytes: 250,
+			wantSpeed: 500,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mergeTailSample(tt.samples, tt.bytes, tt.offset)
+			last := tt.samples[len(tt.samples)-1]
+			if last.Bytes != tt.wantBytes || last.Offset != tt.offset {
+				t.Errorf("last = %+v, want bytes %d offset %s", last, tt.wantBytes, tt.offset)
+			}
+			if last.Speed != tt.wantSpeed {
+				t.Errorf("speed = %v, want %v", last.Speed, tt.wantSpeed)
+			}
+		})
+	}
+}
+
+func TestSampleThroughputCanceled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(3*sampleInterval, cancel)
+
+	start := time.Now()
+	samples := sampleThroughput(ctx, time.Minute, 0, 2, func(ctx context.Context, w io.Writer) {
+		for ctx.Err() == nil {
+			w.Write(make([]byte, 1024))
+			time.Sleep(10 * time.Millisecond)
+		}
+	})
+	if elapsed := time.Since(start); elapsed > 10*sampleInterval {
+		t.Fatalf("sampleThroughput() returned after %s, want it to stop when the parent context is canceled", elapsed)
+	}
+	if len(samples) == 0 {
+		t.Error("sampleThroughput() returned no samples")
+	}
+}
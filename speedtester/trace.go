@@ -0,0 +1,54 @@
+package speedtester
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/metacubex/mihomo/constant"
+)
+
+// traceExitIP 通过代理访问测速服务器的 /cdn-cgi/trace，返回服务器看到的出口 IP。
+// 出口信息查询失败时用作补充，使自建测速服务器也能提供线路检测所需的出口 IP
+func (st *SpeedTester) traceExitIP(proxy constant.Proxy) (string, error) {
+	backend, ok := st.config.SpeedBackend.(TraceBackend)
+	if !ok {
+		return "", ErrTraceNotSupported
+	}
+	req, err := backend.NewTraceRequest(context.Background())
+	if err != nil {
+		return "", err
+	}
+	resp, err := st.createClient(proxy).Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("trace failed: status %d", resp.StatusCode)
+	}
+	return parseTraceIP(io.LimitReader(resp.Body, 4096))
+}
+
+// parseTraceIP 从 key=value 格式的 trace 响应中取出 ip 字段
+func parseTraceIP(r io.Reader) (string, error) {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		value, ok := strings.CutPrefix(scanner.Text(), "ip=")
+		if !ok {
+			continue
+		}
+		if net.ParseIP(value) == nil {
+			return "", fmt.Errorf("invalid trace ip: %s", value)
+		}
+		return value, nil
+	}
+	if err := scanner.Err(); err != nil {
+		return "", err
+	}
+	return "", fmt.Errorf("trace response has no ip")
+}
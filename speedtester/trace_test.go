@@ -0,0 +1,82 @@
+package speedtester
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/metacubex/mihomo/adapter"
+	"github.com/metacubex/mihomo/adapter/outbound"
+)
+
+func TestParseTraceIP(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    string
+		want    string
+		wantErr bool
+	}{
+		{name: "ipv4", body: "fl=1\nh=example.com\nip=203.0.113.7\nts=1.0\nloc=JP\n", want: "203.0.113.7"},
+		{name: "ipv6", body: "ip=2001:db8::1\n", want: "2001:db8::1"},
+		{name: "missing ip", body: "h=example.com\nloc=JP\n", wantErr: true},
+		{name: "invalid ip", body: "ip=unknown\n", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseTraceIP(strings.NewReader(tt.body))
+			if (err != nil) != tt.wantErr || got != tt.want {
+				t.Errorf("parseTraceIP() = %q, %v, want %q, wantErr %v", got, err, tt.want, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestTraceExitIP(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/cdn-cgi/trace" || r.Header.Get("Authorization") != "Bearer secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte("h=" + r.Host + "\nip=127.0.0.1\nloc=XX\n"))
+	}))
+	defer srv.Close()
+
+	downloadServer, err := NewSpeedBackend("download-server", srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	static, err := NewSpeedBackend("static", srv.URL+"/file.bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name    string
+		backend SpeedBackend
+		want    string
+		wantErr error
+	}{
+		{name: "download-server with token", backend: WithToken(downloadServer, "secret"), want: "127.0.0.1"},
+		{name: "download-server without token", backend: downloadServer},
+		// 不支持 trace 的后端经过 Token 包装后仍然返回 ErrTraceNotSupported
+		{name: "static", backend: WithToken(static, "secret"), wantErr: ErrTraceNotSupported},
+	}
+	proxy := &CProxy{Proxy: adapter.NewProxy(outbound.NewDirect())}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			st := &SpeedTester{config: &Config{SpeedBackend: tt.backend, Timeout: 5 * time.Second}, scheduler: newScheduler(0, 0)}
+			got, err := st.traceExitIP(proxy)
+			if tt.wantErr != nil && !errors.Is(err, tt.wantErr) {
+				t.Errorf("traceExitIP() error = %v, want %v", err, tt.wantErr)
+			}
+			if tt.want != "" && err != nil {
+				t.Fatalf("traceExitIP() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("traceExitIP() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,164 @@
+package speedtester
+
+import (
+	"context"
+	"encoding/binary"
+	"math/rand"
+	"net"
+	"net/netip"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/metacubex/mihomo/constant"
+)
+
+const (
+	// UDP 测试发送的数据包数量
+	udpPacketCount = 20
+	// 相邻两个数据包的发送间隔
+	udpPacketInterval = 50 * time.Millisecond
+	// 最后一个数据包发出后等待回包的时间
+	udpReplyTimeout = 2 * time.Second
+)
+
+// udpResult 中 supported 表示 UDP 中继是否收到了回包，packetLoss 为回显测试的真实丢包率
+type udpResult struct {
+	supported  bool
+	latency    time.Duration
+	packetLoss float64
+}
+
+// testUDP 通过代理的 UDP 中继向 UDPServer 发送数据包并统计往返延迟和丢包率。
+// 目标端口为53时发送 DNS 查询，否则发送回显数据包（配合 download-server 的 UDP 回显使用）
+func (st *SpeedTester) testUDP(proxy constant.Proxy) *udpResult {
+	result := &udpResult{}
+	if !proxy.SupportUDP() {
+		return result
+	}
+
+	addr, err := net.ResolveUDPAddr("udp", st.config.UDPServer)
+	if err != nil {
+		return result
+	}
+	dstIP, ok := netip.AddrFromSlice(addr.IP)
+	if !ok {
+		return result
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), st.config.Timeout)
+	defer cancel()
+	pc, err := st.listenPacket(ctx, proxy, &constant.Metadata{
+		NetWork: constant.UDP,
+		DstIP:   dstIP.Unmap(),
+		DstPort: uint16(addr.Port),
+	})
+	if err != nil {
+		return result
+	}
+
+	isDNS := addr.Port == 53
+	baseID := uint16(rand.Intn(1 << 16))
+	sentAt := make(map[uint16]time.Time, udpPacketCount)
+	var mu sync.Mutex
+	rtts := make([]time.Duration, 0, udpPacketCount)
+
+	// 接收回包，按包头的 ID 匹配发送时间
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		buf := make([]byte, 2048)
+		for {
+			n, _, err := pc.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			if n < 2 {
+				continue
+			}
+			id := binary.BigEndian.Uint16(buf[:2])
+			mu.Lock()
+			if start, ok := sentAt[id]; ok {
+				rtts = append(rtts, time.Since(start))
+				delete(sentAt, id)
+			}
+			received := len(rtts)
+			mu.Unlock()
+			if received == udpPacketCount {
+				return
+			}
+		}
+	}()
+
+	for i := 0; i < udpPacketCount; i++ {
+		// 流量预算用完时不再发送
+		if st.scheduler.exhausted() {
+			break
+		}
+		id := baseID + uint16(i)
+		var packet []byte
+		if isDNS {
+			packet = buildDNSQuery(id, "www.google.com")
+		} else {
+			packet = buildEchoPacket(id)
+		}
+		mu.Lock()
+		sentAt[id] = time.Now()
+		mu.Unlock()
+		if _, err := pc.WriteTo(packet, addr); err != nil {
+			break
+		}
+		time.Sleep(udpPacketInterval)
+	}
+
+	// 等待剩余回包，超时后关闭连接以结束接收
+	select {
+	case <-done:
+	case <-time.After(udpReplyTimeout):
+	}
+	pc.Close()
+	<-done
+
+	mu.Lock()
+	defer mu.Unlock()
+	// ss、trojan 等协议建立 UDP 中继时不与服务器握手，收到至少一个回包才视为支持 UDP，之后统计真实的丢包率
+	if len(rtts) == 0 {
+		return result
+	}
+	result.supported = true
+	result.latency = medianDuration(rtts)
+	result.packetLoss = float64(udpPacketCount-len(rtts)) / udpPacketCount * 100
+	return result
+}
+
+// buildDNSQuery 构造一个查询 A 记录的 DNS 请求
+func buildDNSQuery(id uint16, domain string) []byte {
+	packet := make([]byte, 12, 64)
+	binary.BigEndian.PutUint16(packet[0:2], id)
+	// 标准查询，期望递归
+	binary.BigEndian.PutUint16(packet[2:4], 0x0100)
+	// 一个问题
+	binary.BigEndian.PutUint16(packet[4:6], 1)
+
+	start := 0
+	for i := 0; i <= len(domain); i++ {
+		if i == len(domain) || domain[i] == '.' {
+			packet = append(packet, byte(i-start))
+			packet = append(packet, domain[start:i]...)
+			start = i + 1
+		}
+	}
+	packet = append(packet, 0)
+	// QTYPE A，QCLASS IN
+	packet = append(packet, 0, 1, 0, 1)
+	return packet
+}
+
+// buildEchoPacket 构造回显数据包，前两个字节为包 ID
+func buildEchoPacket(id uint16) []byte {
+	packet := make([]byte, 2, 64)
+	binary.BigEndian.PutUint16(packet, id)
+	packet = append(packet, "clash-speedtest-udp-echo-"...)
+	packet = strconv.AppendInt(packet, time.Now().UnixNano(), 10)
+	return packet
+}
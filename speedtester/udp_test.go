@@ -0,0 +1,104 @@
+package speedtester
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/metacubex/mihomo/adapter"
+	"github.com/metacubex/mihomo/adapter/outbound"
+)
+
+func TestBuildDNSQuery(t *testing.T) {
+	tests := []struct {
+		domain   string
+		question []byte
+	}{
+		{domain: "www.google.com", question: []byte("\x03www\x06google\x03com\x00\x00\x01\x00\x01")},
+		{domain: "a.b", question: []byte("\x01a\x01b\x00\x00\x01\x00\x01")},
+		{domain: "localhost", question: []byte("\x09localhost\x00\x00\x01\x00\x01")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.domain, func(t *testing.T) {
+			packet := buildDNSQuery(0xbeef, tt.domain)
+			header := []byte{0xbe, 0xef, 0x01, 0x00, 0, 1, 0, 0, 0, 0, 0, 0}
+			if string(packet[:12]) != string(header) {
+				t.Errorf("header = %x, want %x", packet[:12], header)
+			}
+			if string(packet[12:]) != string(tt.question) {
+				t.Errorf("question = %q, want %q", packet[12:], tt.question)
+			}
+		})
+	}
+}
+
+// listenUDP 启动本地 UDP 服务，echo 为 false 时只接收不回复
+func listenUDP(t *testing.T, echo bool) string {
+	t.Helper()
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { pc.Close() })
+	go func() {
+		buf := make([]byte, 2048)
+		for {
+			n, addr, err := pc.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			if echo {
+				pc.WriteTo(buf[:n], addr)
+			}
+		}
+	}()
+	return pc.LocalAddr().String()
+}
+
+func TestUDPEcho(t *testing.T) {
+	if testing.Short() {
+		t.Skip("waits for udp replies")
+	}
+	tests := []struct {
+		name          string
+		echo          bool
+		wantSupported bool
+		wantLoss      float64
+	}{
+		{name: "echo", echo: true, wantSupported: true, wantLoss: 0},
+		// 中继建立成功但没有任何回包时视为不支持 UDP
+		{name: "no reply", echo: false, wantSupported: false, wantLoss: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			st := &SpeedTester{
+				config:    &Config{UDPServer: listenUDP(t, tt.echo), Timeout: time.Second},
+				scheduler: newScheduler(0, 0),
+			}
+			proxy := &CProxy{Proxy: adapter.NewProxy(outbound.NewDirect())}
+			result := st.testUDP(proxy)
+			if result.supported != tt.wantSupported || result.packetLoss != tt.wantLoss {
+				t.Errorf("supported = %v, loss = %v, want %v, %v", result.supported, result.packetLoss, tt.wantSupported, tt.wantLoss)
+			}
+			if tt.wantSupported && result.latency <= 0 {
+				t.Errorf("latency = %s, want a positive round trip time", result.latency)
+			}
+			// 发送和收到的流量都计入节点流量
+			wantTraffic := int64(0)
+			if tt.echo {
+				wantTraffic = 2 * int64(udpPacketCount*len(buildEchoPacket(0)))
+			}
+			if got := proxy.traffic.Load(); tt.echo && got < wantTraffic*9/10 {
+				t.Errorf("traffic = %d, want about %d", got, wantTraffic)
+			}
+		})
+	}
+}
+
+func TestBuildEchoPacket(t *testing.T) {
+	packet := buildEchoPacket(42)
+	if id := binary.BigEndian.Uint16(packet[:2]); id != 42 {
+		t.Errorf("id = %d, want 42", id)
+	}
+}
@@ -0,0 +1,292 @@
+package subscription
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/faceair/clash-speedtest/proxylink"
+	"github.com/faceair/clash-speedtest/speedtester"
+	"github.com/faceair/clash-speedtest/utils"
+	"gopkg.in/yaml.v3"
+)
+
+// 订阅的输出格式
+const (
+	FormatClash  = "clash"
+	FormatLinks  = "links"
+	FormatBase64 = "base64"
+)
+
+// Options 是生成订阅时的过滤和重命名参数，与命令行的同名参数含义相同
+type Options struct {
+	MaxLatency time.Duration
+	// 单位 MB/s
+	MinDownloadSpeed float64
+	MinUploadSpeed   float64
+	RejectThrottled  bool
+	// 单位 %
+	MaxPacketLoss  float64
+	MaxFailureRate float64
+	OnlyRelayed    bool
+	// 国家代码，例如 JP，多个用逗号分隔，为空时不过滤
+	Country string
+	Limit   int
+	// 节点重命名模式: add|overwrite|none
+	RenameMode string
+	// Fast 模式下不根据速度过滤，名称中也不添加速度
+	Fast bool
+}
+
+// Filter 按 Options 过滤测试结果，保持原有顺序
+func Filter(results []*speedtester.Result, options *Options) []*speedtester.Result {
+	countries := make(map[string]bool)
+	for _, country := range strings.Split(options.Country, ",") {
+		if country = strings.TrimSpace(country); country != "" {
+			countries[strings.ToUpper(country)] = true
+		}
+	}
+
+	filteredResults := make([]*speedtester.Result, 0)
+	for _, result := range results {
+		if options.MaxLatency > 0 && result.Latency > options.MaxLatency {
+			continue
+		}
+		// 在Fast模式下不根据速度过滤
+		if !options.Fast {
+			if options.MinDownloadSpeed > 0 && float64(result.DownloadSpeed)/(1024*1024) < options.MinDownloadSpeed {
+				continue
+			}
+			if options.MinUploadSpeed > 0 && float64(result.UploadSpeed)/(1024*1024) < options.MinUploadSpeed {
+				continue
+			}
+			if options.RejectThrottled && result.Throttled {
+				continue
+			}
+		}
+		// 丢包率只在UDP测试收到回包时才参与过滤
+		if result.UDPSupported && result.PacketLoss > options.MaxPacketLoss {
+			continue
+		}
+		if result.RequestFailureRate > options.MaxFailureRate {
+			continue
+		}
+		if !result.CNReachable {
+			continue
+		}
+		// 只保留经过中转的节点
+		if options.OnlyRelayed && result.RouteType != speedtester.RouteRelayed && result.RouteType != speedtester.RouteTransit {
+			continue
+		}
+		if len(countries) > 0 && !countries[strings.ToUpper(result.IpInfoResult.Country)] {
+			continue
+		}
+		filteredResults = append(filteredResults, result)
+	}
+
+	// 应用limit参数限制代理数量
+	if options.Limit > 0 && len(filteredResults) > options.Limit {
+		filteredResults = filteredResults[:options.Limit]
+	}
+	return filteredResults
+}
+
+// Sort 按默认规则排序：Fast 模式下按延迟排序，否则按下载速度排序，延迟为0（N/A）的节点排在最后
+func Sort(results []*speedtester.Result, fast bool) {
+	if fast {
+		sort.Slice(results, func(i, j int) bool {
+			// 处理延迟为0（N/A）的情况
+			if results[i].Latency == 0 && results[j].Latency > 0 {
+				return false
+			}
+			if results[i].Latency > 0 && results[j].Latency == 0 {
+				return true
+			}
+			// 如果两者都有有效延迟或都为N/A，则按延迟值排序（延迟越低越好）
+			return results[i].Latency < results[j].Latency
+		})
+		return
+	}
+
+	sort.Slice(results, func(i, j int) bool {
+		// 如果下载速度不同，按下载速度排序（下载速度越高越好）
+		if results[i].DownloadSpeed != results[j].DownloadSpeed {
+			return results[i].DownloadSpeed > results[j].DownloadSpeed
+		}
+
+		// 如果下载速度相同，处理延迟为0（N/A）的情况
+		if results[i].Latency == 0 && results[j].Latency > 0 {
+			return false
+		}
+		if results[i].Latency > 0 && results[j].Latency == 0 {
+			return true
+		}
+
+		// 如果两者都有有效延迟或都为N/A，则按延迟值排序（延迟越低越好）
+		if results[i].Latency != results[j].Latency {
+			return results[i].Latency < results[j].Latency
+		}
+
+		// 如果延迟也相同，按名称排序
+		return results[i].ProxyName < results[j].ProxyName
+	})
+}
+
+// Rename 按重命名模式生成节点名称，同一国家的节点按顺序编号
+func Rename(results []*speedtester.Result, options *Options) []string {
+	names := make([]string, 0, len(results))
+	countryCount := make(map[string]int)
+	for _, result := range results {
+		// 构建新的节点名称格式
+		originalName := result.ProxyName
+		newName := ""
+
+		// 根据rename模式处理节点名称
+		switch options.RenameMode {
+		case "none":
+			// 不重命名，使用原始名称
+			names = append(names, originalName)
+			continue
+		case "add":
+			// 在原始名称后添加新信息
+			newName = originalName
+			if result.IpInfoResult.Country != "" {
+				if result.IpInfoResult.CountryFlag != "" {
+					newName += " " + result.IpInfoResult.CountryFlag
+				}
+				newName += locationInfo(result, countryCount)
+			}
+		case "overwrite":
+			fallthrough
+		default:
+			// 默认为overwrite模式，完全重写节点名称
+			if result.IpInfoResult.Country != "" {
+				newName += result.IpInfoResult.CountryFlag
+				newName += locationInfo(result, countryCount)
+			} else {
+				// 如果没有国家信息，使用原始名称
+				newName = originalName
+			}
+		}
+
+		// 添加下载和上传速度信息（非Fast模式下）
+		if !options.Fast {
+			newName += fmt.Sprintf(" ⬇%s ⬆%s", result.FormatDownloadSpeed(), result.FormatUploadSpeed())
+		}
+		// 添加流媒体解锁信息
+		if len(result.UnlockResults) > 0 {
+			unlockResults := make([]string, 0)
+			for platform, unlockResult := range result.UnlockResults {
+				if unlockResult.Status == "Success" {
+					regionInfo := ""
+					if unlockResult.Region != "" {
+						regionInfo = "(" + unlockResult.Region + ")"
+					}
+					unlockResults = append(unlockResults, platform+regionInfo)
+				}
+			}
+			if len(unlockResults) > 0 {
+				newName += " [" + strings.Join(unlockResults, "| ") + "]"
+			}
+		}
+		names = append(names, newName)
+	}
+	return names
+}
+
+// locationInfo 返回中文国家名称及编号、风险信息和地区信息
+func locationInfo(result *speedtester.Result, countryCount map[string]int) string {
+	info := ""
+	if chineseName, ok := utils.CountryCodeMap[result.IpInfoResult.Country]; ok {
+		countryCount[chineseName] += 1
+		info += fmt.Sprintf("%s%d", chineseName, countryCount[chineseName])
+	}
+	if result.IpInfoResult.RiskInfo != "" {
+		info += " " + result.IpInfoResult.RiskInfo
+	}
+	if result.IpInfoResult.Region != "" && result.IpInfoResult.Region != "N/A" {
+		info += " " + result.IpInfoResult.Region
+	}
+	if result.IpInfoResult.City != "" && result.IpInfoResult.City != "N/A" {
+		info += " " + result.IpInfoResult.City
+	}
+	return info
+}
+
+// Links 为每个节点生成代理链接，无法生成链接的节点使用名称代替
+func Links(results []*speedtester.Result, names []string) []string {
+	lines := make([]string, 0, len(results))
+	for i, result := range results {
+		link, ok := proxyLink(result, names[i])
+		if !ok {
+			link = names[i]
+		}
+		lines = append(lines, link)
+	}
+	return lines
+}
+
+// ProxyLinks 与 Links 相同，但跳过无法生成链接的节点，订阅客户端无法识别只有名称的行
+func ProxyLinks(results []*speedtester.Result, names []string) []string {
+	lines := make([]string, 0, len(results))
+	for i, result := range results {
+		if link, ok := proxyLink(result, names[i]); ok {
+			lines = append(lines, link)
+		}
+	}
+	return lines
+}
+
+// proxyLink 生成节点的代理链接，Proxy Provider 中的节点没有单独的配置，无法生成链接
+func proxyLink(result *speedtester.Result, name string) (string, bool) {
+	if result.Provider != "" {
+		return "", false
+	}
+	link, err := proxylink.GenerateProxyLink(name, result.ProxyType, result.ProxyConfig)
+	if err != nil {
+		return "", false
+	}
+	// 对URL进行解码处理
+	if decodedLink, err := url.QueryUnescape(link); err == nil {
+		link = decodedLink
+	}
+	return link, true
+}
+
+// Clash 生成只包含 proxies 的 Clash 配置，节点名称替换为 names 中的名称
+func Clash(results []*speedtester.Result, names []string) ([]byte, error) {
+	proxies := make([]map[string]any, 0, len(results))
+	for i, result := range results {
+		// Proxy Provider 中的节点没有单独的配置，ProxyConfig 是 Provider 的配置，无法导出
+		if result.Provider != "" || len(result.ProxyConfig) == 0 {
+			continue
+		}
+		proxy := make(map[string]any, len(result.ProxyConfig))
+		for k, v := range result.ProxyConfig {
+			proxy[k] = v
+		}
+		proxy["name"] = names[i]
+		proxies = append(proxies, proxy)
+	}
+	return yaml.Marshal(map[string]any{"proxies": proxies})
+}
+
+// Generate 过滤已排序的结果并按 format 生成订阅内容
+func Generate(results []*speedtester.Result, options *Options, format string) ([]byte, error) {
+	results = Filter(results, options)
+	names := Rename(results, options)
+	switch format {
+	case FormatClash:
+		return Clash(results, names)
+	case FormatLinks, "":
+		return []byte(strings.Join(ProxyLinks(results, names), "\n")), nil
+	case FormatBase64:
+		links := strings.Join(ProxyLinks(results, names), "\n")
+		return []byte(base64.StdEncoding.EncodeToString([]byte(links))), nil
+	default:
+		return nil, fmt.Errorf("unsupported format: %s", format)
+	}
+}
@@ -0,0 +1,158 @@
+package subscription
+
+import (
+	"encoding/base64"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/faceair/clash-speedtest/speedtester"
+	"gopkg.in/yaml.v3"
+)
+
+func testResult(name string, latency time.Duration, download float64) *speedtester.Result {
+	return &speedtester.Result{
+		ProxyName:     name,
+		ProxyType:     "Trojan",
+		ProxyConfig:   map[string]any{"name": name, "type": "trojan", "server": "example.com", "port": 443, "password": "secret"},
+		Latency:       latency,
+		DownloadSpeed: download,
+		CNReachable:   true,
+	}
+}
+
+func names(results []*speedtester.Result) []string {
+	names := make([]string, 0, len(results))
+	for _, result := range results {
+		names = append(names, result.ProxyName)
+	}
+	return names
+}
+
+func TestFilter(t *testing.T) {
+	const mb = 1024 * 1024
+	jp := testResult("jp", 100*time.Millisecond, 10*mb)
+	jp.IpInfoResult.Country = "JP"
+	slow := testResult("slow", 100*time.Millisecond, 1*mb)
+	laggy := testResult("laggy", time.Second, 20*mb)
+	blocked := testResult("blocked", 50*time.Millisecond, 20*mb)
+	blocked.CNReachable = false
+	lossy := testResult("lossy", 50*time.Millisecond, 20*mb)
+	lossy.UDPSupported, lossy.PacketLoss = true, 30
+	throttled := testResult("throttled", 50*time.Millisecond, 20*mb)
+	throttled.Throttled = true
+	relayed := testResult("relayed", 50*time.Millisecond, 20*mb)
+	relayed.RouteType = speedtester.RouteRelayed
+	crossASN := testResult("cross-asn", 50*time.Millisecond, 20*mb)
+	crossASN.RouteType = speedtester.RouteCrossASN
+	all := []*speedtester.Result{jp, slow, laggy, blocked, lossy, throttled, relayed, crossASN}
+
+	tests := []struct {
+		name    string
+		options Options
+		want    []string
+	}{
+		{name: "default", options: Options{MaxPacketLoss: 100, MaxFailureRate: 100}, want: []string{"jp", "slow", "laggy", "lossy", "throttled", "relayed", "cross-asn"}},
+		{name: "max latency", options: Options{MaxLatency: 500 * time.Millisecond, MaxPacketLoss: 100, MaxFailureRate: 100}, want: []string{"jp", "slow", "lossy", "throttled", "relayed", "cross-asn"}},
+		{name: "min download", options: Options{MinDownloadSpeed: 5, MaxPacketLoss: 100, MaxFailureRate: 100}, want: []string{"jp", "laggy", "lossy", "throttled", "relayed", "cross-asn"}},
+		{name: "fast ignores speed", options: Options{Fast: true, MinDownloadSpeed: 5, RejectThrottled: true, MaxPacketLoss: 100, MaxFailureRate: 100}, want: []string{"jp", "slow", "laggy", "lossy", "throttled", "relayed", "cross-asn"}},
+		{name: "packet loss", options: Options{MaxPacketLoss: 10, MaxFailureRate: 100}, want: []string{"jp", "slow", "laggy", "throttled", "relayed", "cross-asn"}},
+		{name: "reject throttled", options: Options{RejectThrottled: true, MaxPacketLoss: 100, MaxFailureRate: 100}, want: []string{"jp", "slow", "laggy", "lossy", "relayed", "cross-asn"}},
+		{name: "only relayed", options: Options{OnlyRelayed: true, MaxPacketLoss: 100, MaxFailureRate: 100}, want: []string{"relayed"}},
+		{name: "country", options: Options{Country: "jp, us", MaxPacketLoss: 100, MaxFailureRate: 100}, want: []string{"jp"}},
+		{name: "limit", options: Options{Limit: 2, MaxPacketLoss: 100, MaxFailureRate: 100}, want: []string{"jp", "slow"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := names(Filter(all, &tt.options))
+			if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("Filter() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestClash(t *testing.T) {
+	plain := testResult("plain", 100*time.Millisecond, 0)
+	provider := testResult("[sub] node", 100*time.Millisecond, 0)
+	provider.Provider = "sub"
+	provider.ProxyConfig = map[string]any{"type": "http", "url": "https://example.com/sub", "interval": 3600}
+	empty := testResult("empty", 100*time.Millisecond, 0)
+	empty.ProxyConfig = nil
+
+	tests := []struct {
+		name    string
+		results []*speedtester.Result
+		names   []string
+		want    []string
+	}{
+		{name: "renamed", results: []*speedtester.Result{plain}, names: []string{"JP 1"}, want: []string{"JP 1"}},
+		{name: "provider skipped", results: []*speedtester.Result{provider, plain}, names: []string{"p", "JP 1"}, want: []string{"JP 1"}},
+		{name: "no config skipped", results: []*speedtester.Result{empty}, names: []string{"e"}, want: []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			data, err := Clash(tt.results, tt.names)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var config struct {
+				Proxies []map[string]any `yaml:"proxies"`
+			}
+			if err := yaml.Unmarshal(data, &config); err != nil {
+				t.Fatal(err)
+			}
+			got := make([]string, 0, len(config.Proxies))
+			for _, proxy := range config.Proxies {
+				if proxy["type"] != "trojan" {
+					t.Errorf("exported proxy %v is not a node config", proxy)
+				}
+				got = append(got, proxy["name"].(string))
+			}
+			if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("Clash() proxies = %v, want %v", got, tt.want)
+			}
+		})
+	}
+	// 导出时不应修改原始配置
+	if plain.ProxyConfig["name"] != "plain" {
+		t.Errorf("ProxyConfig name changed to %v", plain.ProxyConfig["name"])
+	}
+}
+
+func TestLinksProvider(t *testing.T) {
+	provider := testResult("[sub] node", 100*time.Millisecond, 0)
+	provider.Provider = "sub"
+	lines := Links([]*speedtester.Result{provider}, []string{"renamed"})
+	if len(lines) != 1 || lines[0] != "renamed" {
+		t.Errorf("Links() = %v, want the name of the provider node", lines)
+	}
+}
+
+func TestGenerateSkipsNodesWithoutLinks(t *testing.T) {
+	provider := testResult("[sub] node", 100*time.Millisecond, 0)
+	provider.Provider = "sub"
+	unsupported := testResult("unsupported", 100*time.Millisecond, 0)
+	unsupported.ProxyType = "Unknown"
+	results := []*speedtester.Result{testResult("trojan", 100*time.Millisecond, 0), provider, unsupported}
+	options := &Options{Fast: true, MaxPacketLoss: 100, MaxFailureRate: 100}
+
+	for _, format := range []string{FormatLinks, FormatBase64} {
+		t.Run(format, func(t *testing.T) {
+			data, err := Generate(results, options, format)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if format == FormatBase64 {
+				if data, err = base64.StdEncoding.DecodeString(string(data)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			lines := strings.Split(string(data), "\n")
+			// 只保留能生成链接的节点，不输出只有名称的行
+			if len(lines) != 1 || !strings.HasPrefix(lines[0], "trojan://") {
+				t.Errorf("Generate(%s) = %q, want only the trojan link", format, lines)
+			}
+		})
+	}
+}
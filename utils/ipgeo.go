@@ -0,0 +1,96 @@
+package utils
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// IPGeo 是 IP 的归属国家和自治系统信息
+type IPGeo struct {
+	IP      string
+	Country string
+	ASN     string
+	Org     string
+}
+
+var (
+	ipGeoCache   = make(map[string]*IPGeo)
+	ipGeoCacheMu sync.Mutex
+	ipGeoClient  = &http.Client{Timeout: 5 * time.Second}
+)
+
+// ipGeoURL 是查询 IP 归属信息的接口，通过 HTTPS 请求，免费额度为每月 50000 次
+const ipGeoURL = "https://ipinfo.io/%s/json"
+
+// LookupIPGeo 从本机直接查询 IP 的国家和 ASN，结果在本次运行中缓存。
+// 查询会把 IP 发送给 ipinfo.io，只在开启线路检测时调用
+func LookupIPGeo(ip string) (*IPGeo, error) {
+	ipGeoCacheMu.Lock()
+	if geo, ok := ipGeoCache[ip]; ok {
+		ipGeoCacheMu.Unlock()
+		return geo, nil
+	}
+	ipGeoCacheMu.Unlock()
+
+	resp, err := ipGeoClient.Get(fmt.Sprintf(ipGeoURL, url.PathEscape(ip)))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusTooManyRequests {
+		return nil, fmt.Errorf("lookup %s failed: rate limited", ip)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("lookup %s failed: status %d", ip, resp.StatusCode)
+	}
+
+	geo, err := parseIPGeo(io.LimitReader(resp.Body, 64*1024))
+	if err != nil {
+		return nil, fmt.Errorf("lookup %s failed: %w", ip, err)
+	}
+
+	ipGeoCacheMu.Lock()
+	ipGeoCache[ip] = geo
+	ipGeoCacheMu.Unlock()
+	return geo, nil
+}
+
+// parseIPGeo 解析 ipinfo.io 的响应，私有地址等无法查询的 IP 返回错误
+func parseIPGeo(r io.Reader) (*IPGeo, error) {
+	var data struct {
+		IP      string `json:"ip"`
+		Country string `json:"country"`
+		Org     string `json:"org"`
+		Bogon   bool   `json:"bogon"`
+		Error   *struct {
+			Title   string `json:"title"`
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	if err := json.NewDecoder(r).Decode(&data); err != nil {
+		return nil, err
+	}
+	if data.Error != nil {
+		return nil, fmt.Errorf("%s: %s", data.Error.Title, data.Error.Message)
+	}
+	if data.Bogon {
+		return nil, fmt.Errorf("%s is a reserved address", data.IP)
+	}
+
+	// org 字段形如 "AS13335 Cloudflare, Inc."
+	geo := &IPGeo{IP: data.IP, Country: data.Country}
+	if asn, org, ok := strings.Cut(data.Org, " "); ok && strings.HasPrefix(asn, "AS") {
+		geo.ASN, geo.Org = asn, org
+	} else if strings.HasPrefix(data.Org, "AS") {
+		geo.ASN = data.Org
+	} else {
+		geo.Org = data.Org
+	}
+	return geo, nil
+}
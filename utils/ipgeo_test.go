@@ -0,0 +1,51 @@
+package utils
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseIPGeo(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    string
+		want    IPGeo
+		wantErr bool
+	}{
+		{
+			name: "asn and org",
+			body: `{"ip":"1.1.1.1","country":"AU","org":"AS13335 Cloudflare, Inc."}`,
+			want: IPGeo{IP: "1.1.1.1", Country: "AU", ASN: "AS13335", Org: "Cloudflare, Inc."},
+		},
+		{
+			name: "asn only",
+			body: `{"ip":"1.1.1.1","country":"AU","org":"AS13335"}`,
+			want: IPGeo{IP: "1.1.1.1", Country: "AU", ASN: "AS13335"},
+		},
+		{
+			name: "org without asn",
+			body: `{"ip":"1.1.1.1","country":"AU","org":"Cloudflare"}`,
+			want: IPGeo{IP: "1.1.1.1", Country: "AU", Org: "Cloudflare"},
+		},
+		{name: "bogon", body: `{"ip":"10.0.0.1","bogon":true}`, wantErr: true},
+		{name: "error response", body: `{"status":404,"error":{"title":"Wrong ip","message":"Please provide a valid IP address"}}`, wantErr: true},
+		{name: "invalid json", body: `<html>`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseIPGeo(strings.NewReader(tt.body))
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("parseIPGeo() = %+v, want error", got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseIPGeo() error = %v", err)
+			}
+			if *got != tt.want {
+				t.Errorf("parseIPGeo() = %+v, want %+v", *got, tt.want)
+			}
+		})
+	}
+}